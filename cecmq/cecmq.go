@@ -0,0 +1,279 @@
+// CMQ 的 CloudEvents 绑定
+//
+// 把 CloudEvents 事件编码为 CMQ 消息发送到队列或发布到主题，并从收到的 cmq.Message、
+// cmq.Notification 还原事件。支持两种模式：
+//
+//   - ModeStructured：整个事件按 application/cloudevents+json 编码为 msgBody，不使用信封，
+//     不使用本 SDK 的消费者也可以直接解析
+//   - ModeBinary：事件属性放在消息信封（cmq.Envelope）的 ce- 消息头中，msgBody 为事件数据
+//
+// 发布到主题时可以用 WithRoutingKey 把事件的 type、subject 映射为 routingKey，以便订阅按 bindingKey 过滤事件。
+// msgTag 最长只有 cmq.MaxTagLength 个字符，放不下 com.example.order.created 这样的事件类型，
+// 按标签过滤时需要用 TypeTags 把事件类型映射为短标签。
+package cecmq
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"github.com/zyw/cmq-goclient/cmq"
+)
+
+// 编码模式
+type Mode int
+
+const (
+	ModeStructured Mode = iota
+	ModeBinary
+)
+
+const (
+	//结构化模式的 Content-Type
+	ContentTypeStructured = "application/cloudevents+json"
+	//二进制模式下事件属性消息头的前缀
+	HeaderPrefix = "ce-"
+	//二进制模式下事件数据不是 UTF-8 文本时按 base64 编码，并设置这个消息头
+	HeaderTransferEncoding = "Content-Transfer-Encoding"
+)
+
+type config struct {
+	mode       Mode
+	tags       func(e *event.Event) []string
+	routingKey func(e *event.Event) string
+}
+
+// 配置项
+type Option func(*config)
+
+// 设置编码模式，默认为 ModeStructured
+func WithMode(mode Mode) Option {
+	return func(c *config) {
+		c.mode = mode
+	}
+}
+
+// 发布到主题时用 f 计算 msgTag，比如 TypeTags；每个标签不能超过 cmq.MaxTagLength 个字符
+func WithTags(f func(e *event.Event) []string) Option {
+	return func(c *config) {
+		c.tags = f
+	}
+}
+
+// 发布到主题时用 f 计算 routingKey，比如 TypeRoutingKey、SubjectRoutingKey
+func WithRoutingKey(f func(e *event.Event) string) Option {
+	return func(c *config) {
+		c.routingKey = f
+	}
+}
+
+// 按 tags 把事件的 type 映射为短标签作为 msgTag，不在 tags 中的事件类型不设置标签
+// 标签超过 cmq.MaxTagLength 个字符时 panic
+//
+//	cecmq.WithTags(cecmq.TypeTags(map[string]string{
+//		"com.example.order.created": "order-created",
+//	}))
+func TypeTags(tags map[string]string) func(e *event.Event) []string {
+	for eventType, tag := range tags {
+		if len(tag) == 0 || len(tag) > cmq.MaxTagLength {
+			panic(fmt.Sprintf("cecmq: tag %q of event type %s must be 1-%d characters", tag, eventType, cmq.MaxTagLength))
+		}
+	}
+	return func(e *event.Event) []string {
+		if tag, ok := tags[e.Type()]; ok {
+			return []string{tag}
+		}
+		return nil
+	}
+}
+
+// 把事件的 type 作为 routingKey，比如 com.example.order.created
+func TypeRoutingKey(e *event.Event) string {
+	return e.Type()
+}
+
+// 把事件的 subject 作为 routingKey
+func SubjectRoutingKey(e *event.Event) string {
+	return e.Subject()
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{mode: ModeStructured}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// 把事件编码为消息正文和消息头，结构化模式没有消息头
+func Encode(e *event.Event, mode Mode) (body string, headers map[string]string, err error) {
+	if err := e.Validate(); err != nil {
+		return "", nil, err
+	}
+	if mode == ModeStructured {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return "", nil, err
+		}
+		return string(b), nil, nil
+	}
+
+	headers = map[string]string{
+		HeaderPrefix + "specversion": e.SpecVersion(),
+		HeaderPrefix + "id":          e.ID(),
+		HeaderPrefix + "source":      e.Source(),
+		HeaderPrefix + "type":        e.Type(),
+	}
+	if len(e.Subject()) != 0 {
+		headers[HeaderPrefix+"subject"] = e.Subject()
+	}
+	if !e.Time().IsZero() {
+		headers[HeaderPrefix+"time"] = e.Time().UTC().Format(time.RFC3339Nano)
+	}
+	if len(e.DataSchema()) != 0 {
+		headers[HeaderPrefix+"dataschema"] = e.DataSchema()
+	}
+	if len(e.DataContentType()) != 0 {
+		headers[cmq.HeaderContentType] = e.DataContentType()
+	}
+	for k, v := range e.Extensions() {
+		s, err := types.Format(v)
+		if err != nil {
+			return "", nil, fmt.Errorf("extension %s: %v", k, err)
+		}
+		headers[HeaderPrefix+k] = s
+	}
+	data := e.Data()
+	if utf8.Valid(data) {
+		return string(data), headers, nil
+	}
+	headers[HeaderTransferEncoding] = "base64"
+	return base64.StdEncoding.EncodeToString(data), headers, nil
+}
+
+// 从消息正文和消息头还原事件，根据是否有 ce-specversion 消息头判断编码模式
+func Decode(body string, headers map[string]string) (*event.Event, error) {
+	if specVersion, ok := headers[HeaderPrefix+"specversion"]; ok {
+		return decodeBinary(specVersion, body, headers)
+	}
+	if ct := headers[cmq.HeaderContentType]; len(ct) != 0 && !strings.HasPrefix(ct, ContentTypeStructured) {
+		return nil, fmt.Errorf("not a cloudevent, content type %q", ct)
+	}
+	e := event.New()
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return nil, fmt.Errorf("invalid structured cloudevent: %v", err)
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func decodeBinary(specVersion, body string, headers map[string]string) (*event.Event, error) {
+	e := event.New(specVersion)
+	for k, v := range headers {
+		if !strings.HasPrefix(k, HeaderPrefix) {
+			continue
+		}
+		switch name := k[len(HeaderPrefix):]; name {
+		case "specversion":
+		case "id":
+			e.SetID(v)
+		case "source":
+			e.SetSource(v)
+		case "type":
+			e.SetType(v)
+		case "subject":
+			e.SetSubject(v)
+		case "dataschema":
+			e.SetDataSchema(v)
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid time %q: %v", v, err)
+			}
+			e.SetTime(t)
+		default:
+			e.SetExtension(name, v)
+		}
+	}
+
+	data := []byte(body)
+	if headers[HeaderTransferEncoding] == "base64" {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(body); err != nil {
+			return nil, fmt.Errorf("invalid base64 data: %v", err)
+		}
+	}
+	if len(data) != 0 {
+		if err := e.SetData(headers[cmq.HeaderContentType], data); err != nil {
+			return nil, err
+		}
+	} else if ct := headers[cmq.HeaderContentType]; len(ct) != 0 {
+		e.SetDataContentType(ct)
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// 从队列中收到的消息还原事件
+func FromMessage(m *cmq.Message) (*event.Event, error) {
+	body, headers := m.MsgBody, m.Headers
+	if headers == nil {
+		body, headers, _ = cmq.UnwrapBody(body)
+	}
+	return Decode(body, headers)
+}
+
+// 从 http 订阅推送的消息还原事件
+func FromNotification(n *cmq.Notification) (*event.Event, error) {
+	body, headers := n.MsgBody, n.Headers
+	if headers == nil {
+		body, headers, _ = cmq.UnwrapBody(body)
+	}
+	return Decode(body, headers)
+}
+
+// 把事件发送到队列，返回消息Id
+func SendEvent(ctx context.Context, q *cmq.Queue, e *event.Event, opts ...Option) (string, error) {
+	c := newConfig(opts)
+	body, headers, err := Encode(e, c.mode)
+	if err != nil {
+		return "", err
+	}
+	msgId, cerr := q.WithContext(ctx).SendMessageWithHeaders(body, headers, 0)
+	if cerr != nil {
+		return "", cerr
+	}
+	return msgId, nil
+}
+
+// 把事件发布到主题，返回消息Id
+func PublishEvent(ctx context.Context, t *cmq.Topic, e *event.Event, opts ...Option) (string, error) {
+	c := newConfig(opts)
+	body, headers, err := Encode(e, c.mode)
+	if err != nil {
+		return "", err
+	}
+	var tags []string
+	if c.tags != nil {
+		tags = c.tags(e)
+	}
+	var routingKey string
+	if c.routingKey != nil {
+		routingKey = c.routingKey(e)
+	}
+	msgId, cerr := t.WithContext(ctx).PublishMessageWithHeaders(body, headers, tags, routingKey)
+	if cerr != nil {
+		return "", cerr
+	}
+	return msgId, nil
+}
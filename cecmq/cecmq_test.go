@@ -0,0 +1,102 @@
+package cecmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/zyw/cmq-goclient/cmq"
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func newEvent(t *testing.T) *event.Event {
+	e := event.New()
+	e.SetID("evt-1")
+	e.SetSource("/orders")
+	e.SetType("com.example.order.created")
+	e.SetSubject("order-1")
+	e.SetTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	e.SetExtension("tenant", "t1")
+	if err := e.SetData(event.ApplicationJSON, map[string]string{"id": "order-1"}); err != nil {
+		t.Fatal(err)
+	}
+	return &e
+}
+
+func TestSendEvent(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	q := cmq.NewAccountDefault(s.URL, "id", "key").GetQueue("queue-a")
+
+	for _, mode := range []Mode{ModeStructured, ModeBinary} {
+		want := newEvent(t)
+		if _, err := SendEvent(context.Background(), q, want, WithMode(mode)); err != nil {
+			t.Fatal(err)
+		}
+		m, cerr := q.ReceiveMessage(0)
+		if cerr != nil {
+			t.Fatal(cerr)
+		}
+		q.DeleteMessage(m.ReceiptHandle)
+
+		got, err := FromMessage(m)
+		if err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+		if got.ID() != want.ID() || got.Type() != want.Type() || got.Subject() != want.Subject() ||
+			!got.Time().Equal(want.Time()) || got.Extensions()["tenant"] != "t1" ||
+			string(got.Data()) != string(want.Data()) || got.DataContentType() != event.ApplicationJSON {
+			t.Errorf("mode %d: got %v", mode, got)
+		}
+	}
+}
+
+func TestPublishEvent(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	topic := cmq.NewAccountDefault(s.URL, "id", "key").GetTopic("topic-a")
+
+	if _, err := PublishEvent(context.Background(), topic, newEvent(t),
+		WithMode(ModeBinary), WithTags(TypeTags(map[string]string{"com.example.order.created": "order-created"})),
+		WithRoutingKey(TypeRoutingKey)); err != nil {
+		t.Fatal(err)
+	}
+	pub := s.Published("topic-a")
+	if len(pub) != 1 || len(pub[0].Tags) != 1 || pub[0].Tags[0] != "order-created" || pub[0].RoutingKey != "com.example.order.created" {
+		t.Fatalf("unexpected published message %+v", pub)
+	}
+	e, err := FromNotification(&cmq.Notification{MsgBody: pub[0].Body})
+	if err != nil || e.ID() != "evt-1" {
+		t.Errorf("FromNotification() = %v, %v", e, err)
+	}
+}
+
+func TestTypeTags(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("TypeTags should panic on a tag longer than cmq.MaxTagLength")
+		}
+	}()
+	TypeTags(map[string]string{"com.example.order.created": "com.example.order.created"})
+}
+
+func TestDecode_BinaryData(t *testing.T) {
+	e := newEvent(t)
+	e.SetData("application/octet-stream", []byte{0xff, 0x00, 0x01})
+	body, headers, err := Encode(e, ModeBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers[HeaderTransferEncoding] != "base64" {
+		t.Errorf("binary data should be base64 encoded, headers %v", headers)
+	}
+	got, err := Decode(body, headers)
+	if err != nil || string(got.Data()) != string(e.Data()) {
+		t.Errorf("Decode() = %v, %v", got, err)
+	}
+
+	if _, err := Decode("plain text", nil); err == nil {
+		t.Error("Decode() of a plain body should fail")
+	}
+}
@@ -0,0 +1,127 @@
+package cmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	//消息正文保存在 BlobStore 中时，这个消息头为 blob 的引用，msgBody 也是这个引用
+	HeaderClaimCheck = "Claim-Check"
+	//消息正文超过多少字节时保存到 BlobStore 的缺省值，不超过主题消息的默认最大长度 64K
+	DefaultClaimCheckThreshold = 65536
+)
+
+// blob 不存在
+var ErrBlobNotFound = errors.New("blob not found")
+
+// 保存大消息正文的存储，比如对象存储
+type BlobStore interface {
+	//保存数据，返回引用
+	Put(ctx context.Context, data []byte) (ref string, err error)
+	//按引用读取数据，不存在时返回 ErrBlobNotFound
+	Get(ctx context.Context, ref string) ([]byte, error)
+	//删除数据，不存在时不返回错误
+	Delete(ctx context.Context, ref string) error
+}
+
+// 本地目录中的 BlobStore，用于测试或生产者和消费者共享文件系统的场景
+type FileBlobStore struct {
+	dir string
+}
+
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (s *FileBlobStore) Put(ctx context.Context, data []byte) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ref := hex.EncodeToString(b)
+	tmp := s.path(ref) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, s.path(ref)); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return ref, nil
+}
+
+func (s *FileBlobStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	if !validBlobRef(ref) {
+		return nil, ErrBlobNotFound
+	}
+	data, err := ioutil.ReadFile(s.path(ref))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return data, err
+}
+
+func (s *FileBlobStore) Delete(ctx context.Context, ref string) error {
+	if !validBlobRef(ref) {
+		return nil
+	}
+	if err := os.Remove(s.path(ref)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileBlobStore) path(ref string) string {
+	return filepath.Join(s.dir, ref)
+}
+
+// 引用来自消息，不能包含路径
+func validBlobRef(ref string) bool {
+	return len(ref) != 0 && !strings.ContainsAny(ref, `/\.`)
+}
+
+// 把消息正文保存到 store，返回的消息正文为引用，消息头中设置 Claim-Check
+func checkBody(ctx context.Context, body string, headers map[string]string, store BlobStore) (string, map[string]string, error) {
+	ref, err := store.Put(ctx, []byte(body))
+	if err != nil {
+		return "", nil, err
+	}
+	return ref, withHeader(headers, HeaderClaimCheck, ref), nil
+}
+
+// 按 Claim-Check 消息头从 store 读取消息正文，消息头中保留 Claim-Check 用于删除 blob
+func claimBody(ctx context.Context, headers map[string]string, store BlobStore) (string, error) {
+	if store == nil {
+		return "", errors.New("no blob store for claim check " + headers[HeaderClaimCheck])
+	}
+	data, err := store.Get(ctx, headers[HeaderClaimCheck])
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// 设置读取大消息正文的 BlobStore，ReceiveMessage、BatchReceiveMessage 自动读取
+func (a *CmqConfig) SetBlobStore(store BlobStore) {
+	a.blobStore = store
+}
+
+// 删除消息正文保存在 BlobStore 中的 blob，消息不是大消息时什么也不做
+// 发布到主题的消息可能被多个订阅消费，只能在所有订阅都处理完后删除
+func (a *CmqConfig) DeleteBlob(ctx context.Context, m *Message) error {
+	ref := m.Header(HeaderClaimCheck)
+	if len(ref) == 0 || a.blobStore == nil {
+		return nil
+	}
+	return a.blobStore.Delete(ctx, ref)
+}
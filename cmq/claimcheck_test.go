@@ -0,0 +1,85 @@
+package cmq
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestProducer_ClaimCheck(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	dir := t.TempDir()
+	store, err := NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := NewAccountDefault(s.URL, "id", "key")
+	account.SetBlobStore(store)
+
+	p := NewQueueProducer(account.GetQueue("queue-a"))
+	p.SetBlobStore(store, 1024)
+	large := strings.Repeat("x", 2*DefaultMaxMsgSize)
+	for _, body := range []string{large, "small"} {
+		if _, err := p.Send(context.Background(), &ProducerMessage{Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent := s.Messages("queue-a")[0].Body; len(sent) > 1024 {
+		t.Errorf("sent %d bytes, want only the reference", len(sent))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var bodies []string
+	consumer := NewConsumer(account.GetQueue("queue-a"), func(ctx context.Context, m *Message) error {
+		bodies = append(bodies, m.MsgBody)
+		if len(bodies) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	consumer.SetPollingWaitSeconds(0)
+	consumer.SetDeleteBlobs(true)
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop")
+	}
+
+	if len(bodies) != 2 || bodies[0] != large || bodies[1] != "small" {
+		t.Errorf("unexpected bodies, got %d", len(bodies))
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("blob should be deleted, got %d files", len(files))
+	}
+}
+
+func TestFileBlobStore_InvalidRef(t *testing.T) {
+	store, _ := NewFileBlobStore(t.TempDir())
+	if _, err := store.Get(context.Background(), "../secret"); err != ErrBlobNotFound {
+		t.Errorf("Get() = %v, want ErrBlobNotFound", err)
+	}
+}
+
+func TestProducer_ClaimCheckSendFailed(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	dir := t.TempDir()
+	store, _ := NewFileBlobStore(dir)
+	p := NewQueueProducer(NewAccountDefault(s.URL, "id", "key").GetQueue("queue-a"))
+	p.SetBlobStore(store, 1024)
+
+	s.FailNext(SendMessage, 4440)
+	if _, err := p.Send(context.Background(), &ProducerMessage{Body: strings.Repeat("x", 2048)}); err == nil {
+		t.Fatal("Send() should fail")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("blob of the failed message should be deleted, got %d files", len(files))
+	}
+}
@@ -0,0 +1,66 @@
+package cmq
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	//本地时间和服务端时间相差超过这个值时才修正 Timestamp，Date 头只精确到秒
+	clockSkewTolerance = 2 * time.Second
+	//鉴权失败，Timestamp 过期时也返回这个错误码
+	codeAuthFailed = 4100
+)
+
+// 根据服务端响应的 Date 头估算的本地时钟偏差
+type clockSkew struct {
+	mu       sync.Mutex
+	measured time.Duration
+	offset   time.Duration
+}
+
+// 修正后的当前时间，用于请求的 Timestamp
+func (c *clockSkew) now() time.Time {
+	return time.Now().Add(c.currentOffset())
+}
+
+func (c *clockSkew) currentOffset() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset
+}
+
+// 记录一次请求的偏差：服务端时间减去请求发出和收到响应的中间时刻
+// 往返时间超过容差的样本不可靠，直接忽略：长轮询的 Date 头是等待结束时的时间，
+// 用中间时刻会把正确的本地时钟算成偏差了一半的等待时间
+func (c *clockSkew) observe(sent, received, serverDate time.Time) {
+	if received.Sub(sent) > clockSkewTolerance {
+		return
+	}
+	skew := serverDate.Sub(sent.Add(received.Sub(sent) / 2)).Truncate(time.Second)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.measured = skew
+	if skew > clockSkewTolerance || skew < -clockSkewTolerance {
+		c.offset = skew
+	} else {
+		c.offset = 0
+	}
+}
+
+// 最近一次测得的本地时钟偏差，服务端时间比本地快时为正数
+// 偏差超过 2 秒时，请求的 Timestamp 会加上这个偏差
+func (a *CmqConfig) ClockSkew() time.Duration {
+	a.clock.mu.Lock()
+	defer a.clock.mu.Unlock()
+	return a.clock.measured
+}
+
+// 响应是否是可能由时钟偏差引起的鉴权失败
+func isClockSkewError(result string) bool {
+	var res struct {
+		Code int `json:"code"`
+	}
+	return json.Unmarshal([]byte(result), &res) == nil && res.Code == codeAuthFailed
+}
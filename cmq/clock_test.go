@@ -0,0 +1,62 @@
+package cmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestClient_ClockSkew(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	s.RequireSignature(map[string]string{"id": "key"})
+	s.SetClockOffset(-time.Hour)
+
+	account := NewAccountDefault(s.URL, "id", "key")
+	if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err != nil {
+		t.Fatal(err)
+	}
+	if skew := account.ClockSkew(); skew > -59*time.Minute || skew < -61*time.Minute {
+		t.Errorf("measured skew = %v", skew)
+	}
+	if calls := s.Calls(); len(calls) != 1 {
+		t.Errorf("first request should be rejected before reaching the handler, calls %v", calls)
+	}
+
+	// 之后的请求直接使用修正后的时间
+	if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err != nil {
+		t.Fatal(err)
+	}
+	if calls := s.Calls(); len(calls) != 2 {
+		t.Errorf("calls %v", calls)
+	}
+
+	s.SetClockOffset(0)
+	if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err != nil {
+		t.Fatal(err)
+	}
+	if skew := account.ClockSkew(); skew != 0 {
+		t.Errorf("skew should be reset, got %v", skew)
+	}
+}
+
+func TestClockSkew_IgnoreLongPoll(t *testing.T) {
+	var c clockSkew
+	sent := time.Now()
+	// 10 秒的长轮询，服务端在等待结束时生成 Date 头，本地时钟是准确的
+	received := sent.Add(10 * time.Second)
+	c.observe(sent, received, received)
+	if c.measured != 0 || c.currentOffset() != 0 {
+		t.Errorf("long poll sample should be ignored, measured %v, offset %v", c.measured, c.currentOffset())
+	}
+
+	c.observe(sent, sent.Add(100*time.Millisecond), sent.Add(time.Hour))
+	if c.currentOffset() < 59*time.Minute {
+		t.Errorf("offset = %v, want about 1h", c.currentOffset())
+	}
+	c.observe(sent, received, received)
+	if c.currentOffset() < 59*time.Minute {
+		t.Errorf("long poll sample should not reset the offset, got %v", c.currentOffset())
+	}
+}
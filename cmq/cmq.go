@@ -1,18 +1,26 @@
 package cmq
 
 import (
+	"context"
 	"strings"
 	"log"
 	"errors"
 	"fmt"
 	"encoding/json"
-	"strconv"
 )
 
 type Cmq struct {
 	client *Client
 }
 
+//返回使用ctx发起调用的Cmq
+//ctx用于取消请求，并传递给拦截器（比如链路追踪信息）
+func (cmq *Cmq) WithContext(ctx context.Context) *Cmq {
+	return &Cmq{
+		client:cmq.client.withContext(ctx),
+	}
+}
+
 const (
 	// 缺省消息接收长轮询等待时间
 	DefaultPollingWaitSeconds 	= 	0
@@ -38,6 +46,10 @@ const (
 	Subscribe					=	"Subscribe"
 	//删除订阅
 	Unsubscribe					=	"Unsubscribe"
+	//主题使用 filterTag 标签过滤
+	FilterTypeTag				=	1
+	//主题使用 bindingKey 过滤
+	FilterTypeBindingKey		=	2
 )
 
 type ListQueueResult struct {
@@ -165,48 +177,83 @@ func (meta *QueueMeta) SetRewindSeconds(rewindSeconds int)  {
 	meta.rewindSeconds = rewindSeconds
 }
 
+func (meta *QueueMeta) MaxMsgHeapNum() int {
+	return meta.maxMsgHeapNum
+}
+
+func (meta *QueueMeta) PollingWaitSeconds() int {
+	return meta.pollingWaitSeconds
+}
+
+func (meta *QueueMeta) VisibilityTimeout() int {
+	return meta.visibilityTimeout
+}
+
+func (meta *QueueMeta) MaxMsgSize() int {
+	return meta.maxMsgSize
+}
+
+func (meta *QueueMeta) MsgRetentionSeconds() int {
+	return meta.msgRetentionSeconds
+}
+
+func (meta *QueueMeta) CreateTime() int {
+	return meta.createTime
+}
+
+func (meta *QueueMeta) LastModifyTime() int {
+	return meta.lastModifyTime
+}
+
+func (meta *QueueMeta) ActiveMsgNum() int {
+	return meta.activeMsgNum
+}
+
+func (meta *QueueMeta) InactiveMsgNum() int {
+	return meta.inactiveMsgNum
+}
+
+func (meta *QueueMeta) RewindmsgNum() int {
+	return meta.rewindmsgNum
+}
+
+// 消息最小未消费时间，从 1970-1-1 00:00:00 到现在的秒值
+func (meta *QueueMeta) MinMsgTime() int {
+	return meta.minMsgTime
+}
+
+func (meta *QueueMeta) DelayMsgNum() int {
+	return meta.delayMsgNum
+}
+
+func (meta *QueueMeta) RewindSeconds() int {
+	return meta.rewindSeconds
+}
+
 // 创建队列
 func (cmq *Cmq) CreateQueue(queueName string,meta *QueueMeta) *CMQError {
 	qn := strings.TrimSpace(queueName)
-	if len(qn) == 0 {
-		log.Println("Invalid parameter:queueName is empty")
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:queueName is empty"),CreateQueue)
-	}
-	params := map[string]interface{} {
-		"queueName":qn,
-	}
-	if meta.maxMsgHeapNum > 0 {
-		params["maxMsgHeapNum"] = meta.maxMsgHeapNum
-	}
-	if meta.pollingWaitSeconds > 0 {
-		params["pollingWaitSeconds"] = meta.pollingWaitSeconds
-	}
-	if meta.visibilityTimeout > 0 {
-		params["visibilityTimeout"] = meta.visibilityTimeout
-	}
-	if meta.maxMsgSize > 0 {
-		params["maxMsgSize"] = meta.maxMsgSize
+	if err := validateName("queueName",qn);err != nil {
+		log.Println(err.Error())
+		return NewCMQOpError(CMQError100,err,CreateQueue)
 	}
-	if meta.msgRetentionSeconds > 0 {
-		params["msgRetentionSeconds"] = meta.msgRetentionSeconds
+	if meta == nil {
+		meta = NewDefaultQueueMeta()
 	}
-	if meta.rewindSeconds > 0 {
-		params["rewindSeconds"] = meta.rewindSeconds
+	if err := validateQueueMeta(meta);err != nil {
+		return NewCMQOpError(CMQError100,err,CreateQueue)
 	}
-	return handleCmqApi(cmq,CreateQueue, params)
+	return handleCmqApi(cmq,CreateQueue,newQueueAttributesRequest(qn,meta))
 }
 // 删除队列
 func (cmq *Cmq) DeleteQueue(queueName string) *CMQError {
 	qn := strings.TrimSpace(queueName)
-	if len(qn) == 0 {
-		log.Println("Invalid parameter:queueName is empty")
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:queueName is empty"),DeleteQueue)
+	if err := validateName("queueName",qn);err != nil {
+		log.Println(err.Error())
+		return NewCMQOpError(CMQError100,err,DeleteQueue)
 	}
 
-	params := map[string]interface{} {
-		"queueName":qn,
-	}
-	return handleCmqApi(cmq,DeleteQueue,params)
+	return handleCmqApi(cmq,DeleteQueue,&queueRequest{QueueName:qn})
 }
 
 // 队列列表
@@ -215,44 +262,56 @@ func (cmq *Cmq) DeleteQueue(queueName string) *CMQError {
 // limit 分页时本页获取队列的个数，如果不传递该参数，则该参数默认为 20，最大值为 50。
 // queueList 引用类型，存放查询到的queue列表，保存queueName
 func (cmq *Cmq) ListQueue(searchWord string,offset,limit int, queueList []string ) (int,*CMQError) {
-
-	params := map[string]interface{}{}
-
-	if len(searchWord) != 0 {
-		params["searchWord"] = searchWord
+	res, err := cmq.listQueues(searchWord,offset,limit)
+	if err != nil {
+		return 0,err
 	}
 
-	if offset >= 0 {
-		params["offset"] = offset
+	if queueList != nil {
+		for i,qs := range res.QueueList {
+			queueList[i] = qs.QueueName
+		}
 	}
 
-	if limit > 0 {
-		params["limit"] = limit
+	return res.TotalCount,nil
+}
+
+// 返回帐号下的所有队列名称，searchWord 用于过滤队列列表，为空时返回所有队列
+func (cmq *Cmq) ListAllQueues(searchWord string) ([]string,*CMQError) {
+	var names []string
+	for offset := 0;;offset += 50 {
+		res, err := cmq.listQueues(searchWord,offset,50)
+		if err != nil {
+			return nil,err
+		}
+		for _,qs := range res.QueueList {
+			names = append(names,qs.QueueName)
+		}
+		if len(res.QueueList) == 0 || offset + len(res.QueueList) >= res.TotalCount {
+			return names,nil
+		}
 	}
+}
+
+func (cmq *Cmq) listQueues(searchWord string,offset,limit int) (*ListQueueResult,*CMQError) {
 
-	result, err := cmq.client.cmqCall(ListQueue, params)
+	result, err := cmq.client.call(ListQueue, newListRequest(searchWord,offset,limit))
 
 	if err != nil {
-		return 0,err
+		return nil,err
 	}
 
 	var res ListQueueResult
 	if err := json.Unmarshal([]byte(result),&res);err != nil {
 		log.Println("parse json string error, msg: " + err.Error())
-		return 0,NewCMQOpError(CMQError102,jsonUnmarshal,ListQueue)
+		return nil,NewCMQOpError(CMQError102,jsonUnmarshal,ListQueue)
 	}
 	if res.Code != 0 {
 		log.Println(fmt.Sprintf("code:%d, %v, RequestId: %v",res.Code,res.Message,res.RequestId))
-		return 0,NewCMQOpError(erron(res.Code),errors.New(res.Message),ListQueue)
+		return nil,NewCMQOpError(erron(res.Code),errors.New(res.Message),ListQueue)
 	}
 
-	if queueList != nil {
-		for i,qs := range res.QueueList {
-			queueList[i] = qs.QueueName
-		}
-	}
-
-	return res.TotalCount,nil
+	return &res,nil
 }
 
 //创建Topic
@@ -266,34 +325,27 @@ func (cmq *Cmq) ListQueue(searchWord string,offset,limit int, queueList []string
 func (cmq *Cmq) CreateTopic(topicName string,maxMsgSize,filterType int) *CMQError {
 	tn := strings.TrimSpace(topicName)
 
-	if len(tn) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:topicName is empty"),CreateTopic)
-	}
-
-	if maxMsgSize < 1024 || maxMsgSize > 1048576 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter: maxMsgSize > 1024KB or maxMsgSize < 1KB"),CreateTopic)
-	}
-
-	params := map[string]interface{} {
-		"topicName":tn,
-		"filterType":filterType,
-		"maxMsgSize":maxMsgSize,
+	if err := firstInvalid(
+		validateName("topicName",tn),
+		validateRange("maxMsgSize",maxMsgSize,1024,DefaultMaxMsgSize),
+		validateFilterType(filterType));err != nil {
+		return NewCMQOpError(CMQError100,err,CreateTopic)
 	}
 
-	return handleCmqApi(cmq,CreateTopic,params)
+	return handleCmqApi(cmq,CreateTopic,&createTopicRequest{
+		TopicName:tn,
+		MaxMsgSize:maxMsgSize,
+		FilterType:filterType,
+	})
 }
 
 func (cmq *Cmq) DeleteTopic(topicName string) *CMQError {
 	tn := strings.TrimSpace(topicName)
-	if len(tn) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:topicName is empty"),DeleteTopic)
+	if err := validateName("topicName",tn);err != nil {
+		return NewCMQOpError(CMQError100,err,DeleteTopic)
 	}
 
-	params := map[string]interface{} {
-		"topicName":tn,
-	}
-
-	return handleCmqApi(cmq,DeleteTopic,params)
+	return handleCmqApi(cmq,DeleteTopic,&topicRequest{TopicName:tn})
 }
 
 // Topic list
@@ -301,18 +353,7 @@ func (cmq *Cmq) DeleteTopic(topicName string) *CMQError {
 // offset 分页时本页获取主题列表的起始位置。如果填写了该值，必须也要填写 limit 。该值缺省时，后台取默认值 0
 // limit 分页时本页获取主题的个数，如果不传递该参数，则该参数默认为 20，最大值为 50。
 func (cmq *Cmq) ListTopic(searchWord string, vTopicList []string ,offset,limit int) (int,*CMQError) {
-	params := map[string]interface{}{}
-
-	if len(searchWord) != 0 {
-		params["searchWord"] = searchWord
-	}
-	if offset >=0 {
-		params["offset"] = offset
-	}
-	if limit > 0 {
-		params["limit"] = limit
-	}
-	result, err := cmq.client.cmqCall(ListTopic, params)
+	result, err := cmq.client.call(ListTopic, newListRequest(searchWord,offset,limit))
 
 	if err != nil {
 		return 0,err
@@ -350,80 +391,53 @@ func (cmq *Cmq) CreateSubscribe(topicName,subscriptionName,endpoint,protocal str
 	notifyStrategy,notifyContentFormat string) *CMQError {
 
 	tn := strings.TrimSpace(topicName)
-	if len(tn) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:topicName is empty"),Subscribe)
-	}
-
 	ssn := strings.TrimSpace(subscriptionName)
-	if len(ssn) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:subscriptionName is empty"),Subscribe)
-	}
-
 	ep := strings.TrimSpace(endpoint)
-	if len(ep) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:endpoint is empty"),Subscribe)
-	}
 	p := strings.TrimSpace(protocal)
-	if len(p) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:protocal is empty"),Subscribe)
-	}
-
 	ns := strings.TrimSpace(notifyStrategy)
-	if len(ns) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:NotifyStrategy is empty"),Subscribe)
-	}
-
 	ncf := strings.TrimSpace(notifyContentFormat)
-	if len(ncf) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:NotifyContentFormat is empty"),Subscribe)
-	}
 
-	if len(filterTag) > 5 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter: Tag number > 5"),Subscribe)
-	}
-
-	params := map[string]interface{} {
-		"topicName":tn,
-		"subscriptionName":ssn,
-		"endpoint":ep,
-		"protocol":p,
-		"notifyStrategy":ns,
-		"notifyContentFormat":ncf,
-	}
-
-	for i,ft := range filterTag {
-		params["filterTag."+ strconv.Itoa(i+1)] = ft
-	}
-	for i,bk := range bindingKey {
-		params["bindingKey."+ strconv.Itoa(i+1)] = bk
-	}
-
-	return handleCmqApi(cmq,Subscribe,params)
+	if err := firstInvalid(
+		validateName("topicName",tn),
+		validateName("subscriptionName",ssn),
+		validateOneOf("protocol",p,ProtocolHttp,ProtocolQueue),
+		validateEndpoint(p,ep),
+		validateOneOf("notifyStrategy",ns,NotifyStrategyDefault,NotifyStrategyExponentialDecay),
+		validateNotifyContentFormat(p,ncf),
+		validateTags("filterTag",filterTag),
+		validateBindingKeys("bindingKey",bindingKey));err != nil {
+		return NewCMQOpError(CMQError100,err,Subscribe)
+	}
+
+	return handleCmqApi(cmq,Subscribe,&subscribeRequest{
+		TopicName:tn,
+		SubscriptionName:ssn,
+		Endpoint:ep,
+		Protocol:p,
+		NotifyStrategy:ns,
+		NotifyContentFormat:ncf,
+		FilterTag:filterTag,
+		BindingKey:bindingKey,
+	})
 }
 // 删除订阅
 // topicName 主题名字，在单个地域同一帐号下唯一。主题名称是一个不超过 64 个字符的字符串，必须以字母为首字符，剩余部分可以包含字母、数字和横划线(-)。
 // subscriptionName 订阅名字，在单个地域同一帐号的同一主题下唯一。订阅名称是一个不超过 64 个字符的字符串，必须以字母为首字符，剩余部分可以包含字母、数字和横划线(-)。
 func (cmq *Cmq) DeleteSubscribe(topicName,subscriptionName string) *CMQError {
 	tn := strings.TrimSpace(topicName)
-	if len(tn) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:topicName is empty"),Unsubscribe)
-	}
-
 	ssn := strings.TrimSpace(subscriptionName)
-	if len(ssn) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter:subscriptionName is empty"),Unsubscribe)
-	}
-
-	params := map[string]interface{} {
-		"topicName":topicName,
-		"subscriptionName":subscriptionName,
+	if err := firstInvalid(validateName("topicName",tn),validateName("subscriptionName",ssn));err != nil {
+		return NewCMQOpError(CMQError100,err,Unsubscribe)
 	}
 
-	return handleCmqApi(cmq,Unsubscribe,params)
+	return handleCmqApi(cmq,Unsubscribe,&subscriptionRequest{
+		TopicName:tn,
+		SubscriptionName:ssn,
+	})
 }
 
-func handleCmqApi(cmq *Cmq,action string,params map[string]interface{}) *CMQError {
-	result, err := cmq.client.cmqCall(action, params)
+func handleCmqApi(cmq *Cmq,action string,req interface{}) *CMQError {
+	result, err := cmq.client.call(action, req)
 	if err != nil {
 		log.Println("create queue error msg: " + err.Error())
 		return err
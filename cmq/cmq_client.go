@@ -1,11 +1,13 @@
 package cmq
 
 import (
+	"context"
 	"time"
 	"math/rand"
 	"strings"
 	"io/ioutil"
 	"errors"
+	"log"
 	"net/http"
 	"github.com/zyw/cmq-goclient/util"
 )
@@ -39,6 +41,8 @@ type Message struct {
 	FirstDequeueTime int64		`json:"firstDequeueTime"`	// 消息第一次出队列的时间，从 1970年1月1日 00:00:00 000 开始的毫秒数
 	DequeueCount int			`json:"dequeueCount"`		// 出队列次数
 	MsgTag []string				`json:"msgTag"`
+	Headers map[string]string	`json:"-"`				// 消息信封中的消息头，不是信封格式的消息为 nil
+	DecodeErr error				`json:"-"`				// 解密或解压消息正文失败的错误，解密失败时为 *DecryptionError
 }
 
 type msg struct {
@@ -69,11 +73,29 @@ type msgInfoList struct {
 type CmqConfig struct {
 	currentVersion string
 	endpoint string
+	//按地域创建时的地域和网络类型
+	region string
+	network string
+	//队列和主题服务各自的endpoint，为空时使用endpoint
+	queueEndpoint string
+	topicEndpoint string
+	//备用endpoint和健康状态
+	health endpointHealth
+	//本地时钟偏差
+	clock clockSkew
 	path string
 	secretId string
 	secretKey string
 	method string
 	signMethod string
+	interceptors []Interceptor
+	metrics Metrics
+	//解密收到的消息使用的密钥
+	keyProvider KeyProvider
+	//加密发送的消息使用的密钥
+	encryptKeys KeyProvider
+	//读取大消息正文的存储
+	blobStore BlobStore
 }
 
 func NewAccountDefault(endpoint, secretId, secretKey string) *CmqConfig  {
@@ -134,6 +156,15 @@ func (a *CmqConfig) GetSubscription(topicName,subscriptionName string) *Subscrip
 
 type Client struct {
 	account *CmqConfig
+	ctx context.Context
+}
+
+// 发起调用使用的ctx，没有设置时为 context.Background()
+func (cc *Client) context() context.Context {
+	if cc.ctx == nil {
+		return context.Background()
+	}
+	return cc.ctx
 }
 
 func newCmqClient(account *CmqConfig) *Client {
@@ -144,6 +175,33 @@ func newCmqClient(account *CmqConfig) *Client {
 
 // 调用CMQ API完成操作，比如：发送消息读取消息，创建队列创建主题
 func (cc *Client) cmqCall(action string,params map[string]interface{}) (result string,e *CMQError)  {
+	return cc.cmqCallContext(cc.context(),action,params)
+}
+
+// 返回使用ctx发起调用的Client副本
+func (cc *Client) withContext(ctx context.Context) *Client {
+	c := *cc
+	c.ctx = ctx
+	return &c
+}
+
+// 依次经过CmqConfig上的拦截器后调用CMQ API
+func (cc *Client) cmqCallContext(ctx context.Context,action string,params map[string]interface{}) (result string,e *CMQError)  {
+	interceptors := cc.account.interceptors
+	if cc.account.metrics != nil {
+		m := cc.account.metrics
+		interceptors = append([]Interceptor{func(ctx context.Context,action string,params map[string]interface{},invoker Invoker) (string,*CMQError) {
+			return observeCall(m,ctx,action,params,invoker)
+		}},interceptors...)
+	}
+	if len(interceptors) == 0 {
+		return cc.invoke(ctx,action,params)
+	}
+	return ChainInterceptors(interceptors...)(ctx,action,params,cc.invoke)
+}
+
+// 签名并发送请求，拦截器链的最后一环
+func (cc *Client) invoke(ctx context.Context,action string,params map[string]interface{}) (result string,e *CMQError)  {
 	if len(action) == 0 {
 		return "",NewCMQOpError(CMQError100,errors.New("action param is Zero value"),action)
 	}
@@ -151,47 +209,90 @@ func (cc *Client) cmqCall(action string,params map[string]interface{}) (result s
 		return "",NewCMQOpError(CMQError100,errors.New("params is nil or len = 0"),action)
 	}
 
+	endpoints := cc.account.routeEndpoints(action)
+	for i,endpoint := range endpoints {
+		result,err := cc.send(ctx,endpoint,action,params)
+		// ctx 结束导致的失败（比如消费者停止时的长轮询）不是 endpoint 的问题
+		if ctx.Err() != nil {
+			return result,err
+		}
+		if err == nil || isEndpointError(err) {
+			cc.account.reportEndpoint(action,endpoint,err)
+		}
+		if err == nil || !canFailover(action,err) || i == len(endpoints) - 1 {
+			return result,err
+		}
+		log.Println("call endpoint " + endpoint + " error, try next endpoint, msg: " + err.Error())
+		countRetry(ctx)
+		if cc.account.metrics != nil {
+			cc.account.metrics.IncRetry(action,resourceName(params))
+		}
+	}
+	return "",NewCMQOpError(CMQError100,errors.New("no endpoint"),action)
+}
+
+// 向endpoint发送请求，因为时钟偏差鉴权失败时用修正后的时间重试一次
+func (cc *Client) send(ctx context.Context,endpoint,action string,params map[string]interface{}) (result string,e *CMQError)  {
+	offset := cc.account.clock.currentOffset()
+	result,e = cc.sendOnce(ctx,endpoint,action,params)
+	if e != nil || !isClockSkewError(result) || cc.account.clock.currentOffset() == offset {
+		return result,e
+	}
+	log.Println("clock skew detected, retry with corrected timestamp, skew: " + cc.account.ClockSkew().String())
+	countRetry(ctx)
+	if cc.account.metrics != nil {
+		cc.account.metrics.IncRetry(action,resourceName(params))
+	}
+	return cc.sendOnce(ctx,endpoint,action,params)
+}
+
+// 签名并向endpoint发送一次请求
+func (cc *Client) sendOnce(ctx context.Context,endpoint,action string,params map[string]interface{}) (result string,e *CMQError)  {
 	params["Action"] = action
 	params["Nonce"] = rand.Int()
 	params["SecretId"] = cc.account.secretId
-	params["Timestamp"] = time.Now().Unix()
+	params["Timestamp"] = cc.account.clock.now().Unix()
 	params["RequestClient"] = cc.account.currentVersion
 
 
 
+	signatureMethod := util.SignatureMethodHmacSHA1
 	if cc.account.signMethod =="sha256" {
-		params["SignatureMethod"] = "HmacSHA256"
-	} else {
-		params["SignatureMethod"] = "HmacSHA1"
+		signatureMethod = util.SignatureMethodHmacSHA256
 	}
+	params["SignatureMethod"] = signatureMethod
 
 	var host string
-	if strings.HasPrefix(cc.account.endpoint,"https") {
-		host = cc.account.endpoint[8:]
+	if strings.HasPrefix(endpoint,"https://") {
+		host = endpoint[8:]
+	} else if strings.HasPrefix(endpoint,"http://") {
+		host = endpoint[7:]
 	} else {
-		host = cc.account.endpoint[7:]
+		return "",NewCMQOpError(CMQError100,errors.New("invalid endpoint: " + endpoint),action)
 	}
 
-	src := cc.account.method + host + cc.account.path + "?" + util.MapToURLParam(params,false)
-
-	params["Signature"] = util.Base64(util.HmacSHA256(src,cc.account.secretKey))
+	params["Signature"] = util.Sign(cc.account.method,host,cc.account.path,params,cc.account.secretKey,signatureMethod)
 	var url string
 	var param string
 	if cc.account.method == "GET" {
-		url = cc.account.endpoint + cc.account.path + "?" + util.MapToURLParam(params,true)
+		url = endpoint + cc.account.path + "?" + util.MapToURLParam(params,true)
 		if len(url) > 2048 {
 			return "",NewCMQOpError(CMQError100,errors.New("URL length is larger than 2K when use GET method"),action)
 		}
 	} else {
-		url = cc.account.endpoint + cc.account.path
+		url = endpoint + cc.account.path
 		param = util.MapToURLParam(params,true)
 	}
 	var userTimeout int
-	if _,ok := params["UserpollingWaitSeconds"];ok {
-		userTimeout = params["UserpollingWaitSeconds"].(int)
+	if t,ok := params["UserpollingWaitSeconds"].(int);ok {
+		userTimeout = t
 	}
 
-	r,err := httpRequest(cc.account.method,url,param,userTimeout)
+	sent := time.Now()
+	r,serverDate,err := httpRequest(ctx,cc.account.method,url,param,userTimeout)
+	if !serverDate.IsZero() {
+		cc.account.clock.observe(sent,time.Now(),serverDate)
+	}
 
 	if err != nil {
 		return "",err
@@ -200,28 +301,30 @@ func (cc *Client) cmqCall(action string,params map[string]interface{}) (result s
 	return r,nil
 }
 
-func httpRequest(method,url,param string,timeout int) (result string,e *CMQError) {
+// 发送http请求，返回响应内容和响应的Date头
+func httpRequest(ctx context.Context,method,url,param string,timeout int) (result string,serverDate time.Time,e *CMQError) {
 	client := &http.Client{
 		Timeout: time.Duration(timeout) * time.Second,
 	}
-	req, err := http.NewRequest(method, url, strings.NewReader(param))
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(param))
 	if err != nil {
-		return "",NewCMQError(CMQError1011,err)
+		return "",time.Time{},NewCMQError(CMQError1011,err)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := client.Do(req)
 
 	if err != nil {
-		return "",NewCMQError(CMQError1012,err)
+		return "",time.Time{},NewCMQError(CMQError1012,err)
 	}
 
 	defer resp.Body.Close()
+	serverDate,_ = http.ParseTime(resp.Header.Get("Date"))
 
 	body, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		return "",NewCMQError(CMQError1013,err)
+		return "",serverDate,NewCMQError(CMQError1013,err)
 	}
-	return string(body),nil
+	return string(body),serverDate,nil
 }
\ No newline at end of file
@@ -3,6 +3,8 @@ package cmq
 import (
 	"testing"
 	"fmt"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
 )
 
 func TestClient_CmqCall(t *testing.T) {
@@ -36,4 +38,22 @@ func TestClient_CmqCall2(t *testing.T) {
 		return
 	}
 	fmt.Println("结果：" + result)
-}
\ No newline at end of file
+}
+func TestClient_Signature(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	s.RequireSignature(map[string]string{"id": "key"})
+
+	for _, signMethod := range []string{"sha256", "sha1"} {
+		for _, method := range []string{"POST", "GET"} {
+			account := NewAccount(s.URL, "id", "key", method, signMethod)
+			if _, err := account.GetQueue("queue-a").SendMessage("hello world&=", 0); err != nil {
+				t.Errorf("%s %s: %v", method, signMethod, err)
+			}
+		}
+	}
+	account := NewAccountDefault(s.URL, "id", "wrong")
+	if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err == nil || err.Code != 4100 {
+		t.Errorf("want authentication failure, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package cmq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	//消息正文的压缩算法，设置了这个消息头的消息正文是压缩后再 base64 编码的结果
+	HeaderContentEncoding = "Content-Encoding"
+	CompressionGzip       = "gzip"
+	//消息正文达到多少字节才压缩的缺省值
+	DefaultCompressionThreshold = 1024
+	//解压后的最大长度，防止很小的恶意消息解压后耗尽内存
+	MaxDecompressedSize = 16 * DefaultMaxMsgSize
+)
+
+// 解压后超过 MaxDecompressedSize
+var ErrDecompressedTooLarge = fmt.Errorf("decompressed body exceeds %d bytes", MaxDecompressedSize)
+
+// 压缩算法，通过 RegisterCompressor 注册
+// 内置 gzip，zstd 和 snappy 在 cmqcompress 包中注册
+type Compressor interface {
+	//算法名，写入 Content-Encoding 消息头
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	//解压结果超过 MaxDecompressedSize 时返回 ErrDecompressedTooLarge，不能先完整解压再检查
+	Decompress(data []byte) ([]byte, error)
+}
+
+var compressors = struct {
+	sync.RWMutex
+	m map[string]Compressor
+}{m: map[string]Compressor{CompressionGzip: gzipCompressor{}}}
+
+// 注册压缩算法，同名的算法会被替换
+func RegisterCompressor(c Compressor) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.m[c.Name()] = c
+}
+
+func compressorFor(name string) (Compressor, bool) {
+	compressors.RLock()
+	defer compressors.RUnlock()
+	c, ok := compressors.m[name]
+	return c, ok
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return CompressionGzip
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	res, err := ioutil.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(res) > MaxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return res, nil
+}
+
+// 用 name 算法压缩消息正文，结果 base64 编码，并在返回的消息头中设置 Content-Encoding
+// 压缩后没有变小时原样返回；不会修改传入的 headers
+func CompressBody(body string, headers map[string]string, name string) (string, map[string]string, error) {
+	c, ok := compressorFor(name)
+	if !ok {
+		return "", nil, fmt.Errorf("unknown compression %q", name)
+	}
+	if len(headers[HeaderContentEncoding]) != 0 {
+		return "", nil, fmt.Errorf("body is already encoded with %s", headers[HeaderContentEncoding])
+	}
+	data, err := c.Compress([]byte(body))
+	if err != nil {
+		return "", nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if len(encoded) >= len(body) {
+		return body, headers, nil
+	}
+	return encoded, withHeader(headers, HeaderContentEncoding, name), nil
+}
+
+// 按 Content-Encoding 消息头解压消息正文，返回的消息头中去掉 Content-Encoding
+// 没有 Content-Encoding 时原样返回
+func DecompressBody(body string, headers map[string]string) (string, map[string]string, error) {
+	name := headers[HeaderContentEncoding]
+	if len(name) == 0 {
+		return body, headers, nil
+	}
+	c, ok := compressorFor(name)
+	if !ok {
+		return "", nil, fmt.Errorf("unknown compression %q", name)
+	}
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", nil, err
+	}
+	if data, err = c.Decompress(data); err != nil {
+		return "", nil, err
+	}
+	return string(data), withHeader(headers, HeaderContentEncoding, ""), nil
+}
+
+// 复制 headers 并设置 key，value 为空时删除 key
+func withHeader(headers map[string]string, key, value string) map[string]string {
+	res := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		res[k] = v
+	}
+	if len(value) == 0 {
+		delete(res, key)
+	} else {
+		res[key] = value
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,193 @@
+package cmq
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	//消费者缺省的长轮询等待时间，单位秒
+	DefaultConsumerPollingWaitSeconds = 10
+	//拉取消息出错后的等待时间
+	consumerErrorBackoff = time.Second
+)
+
+// 处理一条消息，返回 nil 表示处理成功，消费者会删除这条消息；
+// 返回错误时不删除，消息在 visibilityTimeout 之后重新可见，再次被消费
+type Handler func(ctx context.Context, m *Message) error
+
+// 包装 Handler，用于在处理消息前后增加公共逻辑，比如链路追踪、去重、重试
+type HandlerMiddleware func(next Handler) Handler
+
+// 消费者，从一个队列中循环拉取消息并发地交给 Handler 处理
+type Consumer struct {
+	queue              *Queue
+	handler            Handler
+	middlewares        []HandlerMiddleware
+	concurrency        int
+	batchSize          int
+	pollingWaitSeconds int
+	metrics            Metrics
+	deleteBlobs        bool
+	groupBuffer        int
+	groupKey           func(m *Message) string
+}
+
+// 创建消费者，默认单个协程处理，每次最多拉取 16 条消息
+func NewConsumer(q *Queue, handler Handler) *Consumer {
+	return &Consumer{
+		queue:              q,
+		handler:            handler,
+		concurrency:        1,
+		batchSize:          MaxBatchSize,
+		pollingWaitSeconds: DefaultConsumerPollingWaitSeconds,
+		metrics:            NopMetrics{},
+	}
+}
+
+// 设置并发处理消息的协程数
+func (c *Consumer) SetConcurrency(concurrency int) {
+	if concurrency > 0 {
+		c.concurrency = concurrency
+	}
+}
+
+// 设置每次拉取的最大消息数，1-16
+func (c *Consumer) SetBatchSize(batchSize int) {
+	if batchSize > 0 && batchSize <= MaxBatchSize {
+		c.batchSize = batchSize
+	}
+}
+
+// 设置拉取消息的长轮询等待时间，0-30 秒
+func (c *Consumer) SetPollingWaitSeconds(pollingWaitSeconds int) {
+	if pollingWaitSeconds >= 0 && pollingWaitSeconds <= MaxPollingWaitSeconds {
+		c.pollingWaitSeconds = pollingWaitSeconds
+	}
+}
+
+// 设置监控指标
+func (c *Consumer) SetMetrics(m Metrics) {
+	c.metrics = m
+}
+
+// 设置删除消息后是否删除消息正文在 BlobStore 中的 blob，只适用于队列中的消息只有一个消费者处理的场景
+func (c *Consumer) SetDeleteBlobs(deleteBlobs bool) {
+	c.deleteBlobs = deleteBlobs
+}
+
+// 添加 Handler 中间件，先添加的在外层
+func (c *Consumer) Use(middlewares ...HandlerMiddleware) {
+	c.middlewares = append(c.middlewares, middlewares...)
+}
+
+// 队列名
+func (c *Consumer) Name() string {
+	return c.queue.queueName
+}
+
+// 开始消费，直到 ctx 结束；返回前等待正在处理的消息处理完成
+func (c *Consumer) Run(ctx context.Context) error {
+	handler := c.buildHandler()
+	if c.groupBuffer > 0 {
+		return c.runOrdered(ctx, handler)
+	}
+	msgs := make(chan *Message)
+	var wg sync.WaitGroup
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range msgs {
+				c.process(ctx, handler, m)
+			}
+		}()
+	}
+
+	c.poll(ctx, func(m *Message) bool {
+		select {
+		case msgs <- m:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	close(msgs)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// 循环拉取消息交给 dispatch，dispatch 返回 false 时停止
+func (c *Consumer) poll(ctx context.Context, dispatch func(m *Message) bool) {
+	q := c.queue.WithContext(ctx)
+	for ctx.Err() == nil {
+		batch, err := q.BatchReceiveMessage(c.batchSize, c.pollingWaitSeconds)
+		if err != nil {
+			if err.Code != CMQError7000 && ctx.Err() == nil {
+				log.Println("receive message error, msg: " + err.Error())
+				sleep(ctx, consumerErrorBackoff)
+			}
+			continue
+		}
+		c.metrics.AddReceived(c.Name(), len(batch))
+		for i := range batch {
+			if !dispatch(&batch[i]) {
+				return
+			}
+		}
+	}
+}
+
+func (c *Consumer) buildHandler() Handler {
+	// 解密、解压或读取 blob 失败的消息经过中间件（比如 RetryRouter 转发到死信目的地），
+	// 但不交给 Handler，按处理失败处理
+	h := func(ctx context.Context, m *Message) error {
+		if m.DecodeErr != nil {
+			return m.DecodeErr
+		}
+		return c.handler(ctx, m)
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i](h)
+	}
+	return h
+}
+
+// 处理一条消息，成功后删除
+// 处理一条消息，返回 Handler 的错误
+func (c *Consumer) process(ctx context.Context, handler Handler, m *Message) error {
+	c.metrics.AddInFlight(c.Name(), 1)
+	start := time.Now()
+	err := handler(ctx, m)
+	c.metrics.ObserveHandle(c.Name(), err, time.Since(start))
+	c.metrics.AddInFlight(c.Name(), -1)
+	if err != nil {
+		log.Println("handle message error, msgId: " + m.MsgId + ", msg: " + err.Error())
+		return err
+	}
+	// 使用独立的ctx删除，避免消费者停止时已经处理成功的消息没有删除
+	if err := c.queue.DeleteMessage(m.ReceiptHandle); err != nil {
+		log.Println("delete message error, msgId: " + m.MsgId + ", msg: " + err.Error())
+		return nil
+	}
+	c.metrics.AddDeleted(c.Name(), 1)
+	// 无法解码的消息可能被转发到了死信目的地，保留 blob
+	if c.deleteBlobs && m.DecodeErr == nil {
+		if err := c.queue.client.account.DeleteBlob(context.Background(), m); err != nil {
+			log.Println("delete blob error, msgId: " + m.MsgId + ", msg: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// 等待 d 或 ctx 结束
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,169 @@
+package cmq
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestConsumer_Run(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+
+	producer := NewQueueProducer(account.GetQueue("queue-a"))
+	for _, body := range []string{"ok-1", "fail", "ok-2"} {
+		if _, err := producer.Send(context.Background(), &ProducerMessage{Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	handled := map[string]int{}
+	consumer := NewConsumer(account.GetQueue("queue-a"), func(ctx context.Context, m *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled[m.MsgBody]++
+		if len(handled) == 3 {
+			cancel()
+		}
+		if m.MsgBody == "fail" {
+			return errors.New("fail")
+		}
+		return nil
+	})
+	consumer.SetConcurrency(2)
+	consumer.SetPollingWaitSeconds(0)
+
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Run() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop")
+	}
+
+	left := s.Messages("queue-a")
+	if len(left) != 1 || left[0].Body != "fail" {
+		t.Errorf("only the failed message should be left, got %+v", left)
+	}
+}
+
+func TestConsumer_Ordered(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+
+	producer := NewQueueProducer(account.GetQueue("queue-a"))
+	const perGroup = 5
+	groups := []string{"a", "b", "c"}
+	for i := 0; i < perGroup; i++ {
+		for _, g := range groups {
+			m := &ProducerMessage{Body: strconv.Itoa(i), Headers: map[string]string{HeaderGroupKey: g}}
+			if _, err := producer.Send(context.Background(), m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	seen := map[string][]string{}
+	running, maxRunning, total := 0, 0, 0
+	consumer := NewConsumer(account.GetQueue("queue-a"), func(ctx context.Context, m *Message) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		running--
+		g := m.Header(HeaderGroupKey)
+		seen[g] = append(seen[g], m.MsgBody)
+		if total++; total == perGroup*len(groups) {
+			cancel()
+		}
+		return nil
+	})
+	consumer.SetConcurrency(3)
+	consumer.SetBatchSize(4)
+	consumer.SetPollingWaitSeconds(0)
+	consumer.SetOrdered(2)
+
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop")
+	}
+
+	for _, g := range groups {
+		if got := strings.Join(seen[g], ","); got != "0,1,2,3,4" {
+			t.Errorf("group %s handled in order %s", g, got)
+		}
+	}
+	if maxRunning < 2 {
+		t.Errorf("groups should be handled in parallel, max running %d", maxRunning)
+	}
+}
+
+func TestConsumer_OrderedFailure(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	s.VisibilityTimeout = 50 * time.Millisecond
+	account := NewAccountDefault(s.URL, "id", "key")
+	producer := NewQueueProducer(account.GetQueue("queue-a"))
+	for _, body := range []string{"1", "2"} {
+		m := &ProducerMessage{Body: body, Headers: map[string]string{HeaderGroupKey: "g"}}
+		if _, err := producer.Send(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	var handled []string
+	failed := false
+	consumer := NewConsumer(account.GetQueue("queue-a"), func(ctx context.Context, m *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, m.MsgBody)
+		if m.MsgBody == "1" && !failed {
+			failed = true
+			return errors.New("boom")
+		}
+		if m.MsgBody == "2" {
+			cancel()
+		}
+		return nil
+	})
+	consumer.SetBatchSize(2)
+	consumer.SetPollingWaitSeconds(0)
+	consumer.SetOrdered(2)
+
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop")
+	}
+	// 消息 1 处理成功之前不能处理消息 2
+	if got := strings.Join(handled, ","); got != "1,1,2" {
+		t.Errorf("handled %s, want 1,1,2", got)
+	}
+}
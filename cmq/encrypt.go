@@ -0,0 +1,227 @@
+package cmq
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	//消息正文的加密算法，设置了这个消息头的消息正文是加密后再 base64 编码的结果
+	HeaderEncryption = "Encryption"
+	//加密使用的密钥Id
+	HeaderEncryptionKeyId = "Encryption-Key-Id"
+	EncryptionAESGCM      = "AES-GCM"
+)
+
+var (
+	//没有设置 KeyProvider，无法解密
+	ErrNoKeyProvider = errors.New("no key provider")
+	//KeyProvider 中没有这个密钥
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+// 解密消息正文失败
+type DecryptionError struct {
+	MsgId string
+	KeyId string
+	Err   error
+}
+
+func (e *DecryptionError) Error() string {
+	return fmt.Sprintf("decrypt message %s with key %q: %v", e.MsgId, e.KeyId, e.Err)
+}
+
+func (e *DecryptionError) Unwrap() error {
+	return e.Err
+}
+
+// 加密密钥的来源
+type KeyProvider interface {
+	//加密使用的当前密钥和密钥Id
+	CurrentKey() (keyId string, key []byte, err error)
+	//按密钥Id查找解密使用的密钥，密钥轮换后旧的密钥仍然需要能找到，否则之前的消息无法解密
+	Key(keyId string) ([]byte, error)
+}
+
+// 内存中的密钥集合，实现了 KeyProvider
+//
+//	ring := cmq.NewKeyRing()
+//	ring.AddKey("2024-01", key1)
+//	ring.AddKey("2024-06", key2)
+//	ring.SetCurrent("2024-06") // 新消息使用 key2 加密，key1 加密的消息仍然可以解密
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: map[string][]byte{}}
+}
+
+// 添加 AES 密钥，长度为 16、24 或 32 字节；第一个添加的密钥为当前密钥
+func (r *KeyRing) AddKey(keyId string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[keyId] = append([]byte(nil), key...)
+	if len(r.current) == 0 {
+		r.current = keyId
+	}
+	return nil
+}
+
+// 设置加密使用的密钥
+func (r *KeyRing) SetCurrent(keyId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[keyId]; !ok {
+		return ErrUnknownKey
+	}
+	r.current = keyId
+	return nil
+}
+
+func (r *KeyRing) CurrentKey() (string, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.current) == 0 {
+		return "", nil, ErrUnknownKey
+	}
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *KeyRing) Key(keyId string) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[keyId]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// 用 KeyProvider 的当前密钥以 AES-GCM 加密消息正文，结果 base64 编码，
+// 并在返回的消息头中设置 Encryption 和 Encryption-Key-Id；消息头本身不加密
+func EncryptBody(body string, headers map[string]string, kp KeyProvider) (string, map[string]string, error) {
+	keyId, key, err := kp.CurrentKey()
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, err
+	}
+	// 密钥Id作为附加数据，防止被替换为其他密钥Id
+	sealed := aead.Seal(nonce, nonce, []byte(body), []byte(keyId))
+	headers = withHeader(headers, HeaderEncryption, EncryptionAESGCM)
+	headers[HeaderEncryptionKeyId] = keyId
+	return base64.StdEncoding.EncodeToString(sealed), headers, nil
+}
+
+// 按 Encryption 消息头解密消息正文，返回的消息头中去掉 Encryption 和 Encryption-Key-Id
+// 没有 Encryption 时原样返回；失败时返回 *DecryptionError
+func DecryptBody(body string, headers map[string]string, kp KeyProvider) (string, map[string]string, error) {
+	alg := headers[HeaderEncryption]
+	if len(alg) == 0 {
+		return body, headers, nil
+	}
+	keyId := headers[HeaderEncryptionKeyId]
+	plain, err := decrypt(body, alg, keyId, kp)
+	if err != nil {
+		return "", nil, &DecryptionError{KeyId: keyId, Err: err}
+	}
+	headers = withHeader(headers, HeaderEncryption, "")
+	return plain, withHeader(headers, HeaderEncryptionKeyId, ""), nil
+}
+
+func decrypt(body, alg, keyId string, kp KeyProvider) (string, error) {
+	if alg != EncryptionAESGCM {
+		return "", fmt.Errorf("unsupported encryption %q", alg)
+	}
+	if kp == nil {
+		return "", ErrNoKeyProvider
+	}
+	key, err := kp.Key(keyId)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyId))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 设置解密收到的消息使用的密钥，ReceiveMessage、BatchReceiveMessage 自动解密
+func (a *CmqConfig) SetKeyProvider(kp KeyProvider) {
+	a.keyProvider = kp
+}
+
+// 设置加密发送的消息使用的密钥，Queue、Topic 的所有发送方法（包括批量发送）自动加密消息正文，
+// 不通过 Producer 发送的消息（比如 outbox 的 Relay、cecmq）也会加密
+// 已经加密的消息（Producer.SetEncryption）和 Claim-Check 引用不再加密，需要加密 blob 时使用 Producer.SetEncryption
+func (a *CmqConfig) SetEncryption(kp KeyProvider) {
+	a.encryptKeys = kp
+}
+
+// 按 SetEncryption 加密 msgBody，msgBody 可以是信封格式；空的 msgBody 原样返回，由参数校验报错
+func (a *CmqConfig) encryptMsgBody(msgBody string) (string, error) {
+	if a.encryptKeys == nil || len(msgBody) == 0 {
+		return msgBody, nil
+	}
+	body, headers, _ := UnwrapBody(msgBody)
+	if len(headers[HeaderEncryption]) != 0 || len(headers[HeaderClaimCheck]) != 0 {
+		return msgBody, nil
+	}
+	body, headers, err := EncryptBody(body, headers, a.encryptKeys)
+	if err != nil {
+		return "", err
+	}
+	return WrapBody(body, headers), nil
+}
+
+func (a *CmqConfig) encryptMsgBodies(msgBodies []string) ([]string, error) {
+	if a.encryptKeys == nil {
+		return msgBodies, nil
+	}
+	res := make([]string, len(msgBodies))
+	for i, b := range msgBodies {
+		var err error
+		if res[i], err = a.encryptMsgBody(b); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,120 @@
+package cmq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestProducer_Encryption(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	q := account.GetQueue("queue-a")
+
+	ring := NewKeyRing()
+	ring.AddKey("k1", bytes.Repeat([]byte{1}, 32))
+	p := NewQueueProducer(q)
+	p.SetEncryption(ring)
+	p.SetCompression(CompressionGzip, 1)
+	body := strings.Repeat("secret ", 100)
+	if _, err := p.Send(context.Background(), &ProducerMessage{Body: body, Headers: map[string]string{HeaderCorrelationId: "c-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	// 轮换密钥后旧密钥加密的消息仍然可以解密
+	ring.AddKey("k2", bytes.Repeat([]byte{2}, 16))
+	ring.SetCurrent("k2")
+	if _, err := p.Send(context.Background(), &ProducerMessage{Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range s.Messages("queue-a") {
+		if strings.Contains(m.Body, "secret") || strings.Contains(m.Body, "hello") {
+			t.Errorf("plaintext sent: %s", m.Body)
+		}
+	}
+
+	account.SetKeyProvider(ring)
+	msgs, err := q.BatchReceiveMessage(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgs[0].DecodeErr != nil || msgs[0].MsgBody != body || msgs[0].Header(HeaderCorrelationId) != "c-1" || len(msgs[0].Headers) != 1 {
+		t.Errorf("unexpected message %v %v %v", msgs[0].DecodeErr, len(msgs[0].MsgBody), msgs[0].Headers)
+	}
+	if msgs[1].DecodeErr != nil || msgs[1].MsgBody != "hello" {
+		t.Errorf("unexpected message %v %q", msgs[1].DecodeErr, msgs[1].MsgBody)
+	}
+}
+
+func TestDecryptBody_Error(t *testing.T) {
+	ring := NewKeyRing()
+	ring.AddKey("k1", bytes.Repeat([]byte{1}, 32))
+	body, headers, err := EncryptBody("hello", nil, ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewKeyRing()
+	other.AddKey("k1", bytes.Repeat([]byte{9}, 32))
+	m := &Message{MsgId: "msg-1", MsgBody: WrapBody(body, headers)}
+	m.unwrap(context.Background(), other, nil)
+	var de *DecryptionError
+	if !errors.As(m.DecodeErr, &de) || de.MsgId != "msg-1" || de.KeyId != "k1" {
+		t.Errorf("DecodeErr = %v, want *DecryptionError", m.DecodeErr)
+	}
+	if m.Header(HeaderEncryption) != EncryptionAESGCM {
+		t.Error("encrypted body and headers should be kept when decryption fails")
+	}
+
+	if _, _, err := DecryptBody(body, headers, nil); !errors.Is(err, ErrNoKeyProvider) {
+		t.Errorf("DecryptBody() without key provider = %v", err)
+	}
+}
+
+func TestCmqConfig_SetEncryption(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	ring := NewKeyRing()
+	ring.AddKey("k1", bytes.Repeat([]byte{1}, 32))
+	account := NewAccountDefault(s.URL, "id", "key")
+	account.SetEncryption(ring)
+	account.SetKeyProvider(ring)
+	q := account.GetQueue("queue-a")
+
+	if _, err := q.SendMessageWithHeaders("secret-1", map[string]string{"k": "v"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.BatchSendMessage([]string{"secret-2"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Producer 已经加密的消息不再加密
+	p := NewQueueProducer(q)
+	p.SetEncryption(ring)
+	if _, err := p.Send(context.Background(), &ProducerMessage{Body: "secret-3"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range s.Messages("queue-a") {
+		if strings.Contains(m.Body, "secret") {
+			t.Errorf("message sent in plaintext: %s", m.Body)
+		}
+	}
+
+	msgs, err := q.BatchReceiveMessage(3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bodies []string
+	for _, m := range msgs {
+		if m.DecodeErr != nil {
+			t.Fatal(m.DecodeErr)
+		}
+		bodies = append(bodies, m.MsgBody)
+	}
+	if got := strings.Join(bodies, ","); got != "secret-1,secret-2,secret-3" || msgs[0].Header("k") != "v" {
+		t.Errorf("received %s %v", got, msgs[0].Headers)
+	}
+}
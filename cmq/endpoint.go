@@ -0,0 +1,125 @@
+package cmq
+
+import "fmt"
+
+const (
+	//公网访问
+	NetworkPublic = "public"
+	//腾讯云内网访问
+	NetworkInternal = "internal"
+	//队列服务，队列相关的 API 使用这个服务的 endpoint
+	ServiceQueue = "queue"
+	//主题服务，主题和订阅相关的 API 使用这个服务的 endpoint
+	ServiceTopic = "topic"
+)
+
+// 根据地域、网络类型和服务解析 endpoint，返回空字符串表示不支持
+// 私有化部署可以通过 SetEndpointResolver 使用自己的解析规则
+type EndpointResolver func(region, network, service string) string
+
+// 腾讯云公有云的 endpoint 规则
+//
+//	公网：https://cmq-queue-bj.api.qcloud.com
+//	内网：http://cmq-queue-bj.api.tencentyun.com
+func DefaultEndpointResolver(region, network, service string) string {
+	if len(region) == 0 || (service != ServiceQueue && service != ServiceTopic) {
+		return ""
+	}
+	switch network {
+	case NetworkPublic, "":
+		return fmt.Sprintf("https://cmq-%s-%s.api.qcloud.com", service, region)
+	case NetworkInternal:
+		return fmt.Sprintf("http://cmq-%s-%s.api.tencentyun.com", service, region)
+	}
+	return ""
+}
+
+// 主题和订阅相关的 Action，使用主题服务的 endpoint，其他 Action 使用队列服务的 endpoint
+var topicActions = map[string]bool{
+	CreateTopic:                 true,
+	DeleteTopic:                 true,
+	ListTopic:                   true,
+	SetTopicAttributes:          true,
+	GetTopicAttributes:          true,
+	PublishMessage:              true,
+	BatchPublishMessage:         true,
+	Subscribe:                   true,
+	Unsubscribe:                 true,
+	ClearSUbscriptionFIlterTags: true,
+	SetSubscriptionAttributes:   true,
+	GetSubscriptionAttributes:   true,
+	ListSubscriptionByTopic:     true,
+}
+
+// 按地域创建帐号配置，network 为 NetworkPublic 或 NetworkInternal
+// 队列和主题的 endpoint 分别解析，调用时根据 Action 自动选择；地域或网络类型不合法时返回错误
+func NewAccountRegion(region, network, secretId, secretKey string) (*CmqConfig, *CMQError) {
+	if err := firstInvalid(
+		validateRegion("region", region),
+		validateOneOf("network", network, NetworkPublic, NetworkInternal)); err != nil {
+		return nil, NewCMQError(CMQError100, err)
+	}
+	a := NewAccountDefault("", secretId, secretKey)
+	a.region = region
+	a.network = network
+	a.SetEndpointResolver(DefaultEndpointResolver)
+	return a, nil
+}
+
+// 地域
+func (a *CmqConfig) Region() string {
+	return a.region
+}
+
+// 使用 resolver 重新解析队列和主题的 endpoint
+func (a *CmqConfig) SetEndpointResolver(resolver EndpointResolver) {
+	a.queueEndpoint = resolver(a.region, a.network, ServiceQueue)
+	a.topicEndpoint = resolver(a.region, a.network, ServiceTopic)
+}
+
+// 设置队列服务的 endpoint，比如私有化部署的地址
+func (a *CmqConfig) SetQueueEndpoint(endpoint string) {
+	a.queueEndpoint = endpoint
+}
+
+// 设置主题服务的 endpoint
+func (a *CmqConfig) SetTopicEndpoint(endpoint string) {
+	a.topicEndpoint = endpoint
+}
+
+// 队列服务的 endpoint
+func (a *CmqConfig) QueueEndpoint() string {
+	if len(a.queueEndpoint) == 0 {
+		return a.endpoint
+	}
+	return a.queueEndpoint
+}
+
+// 主题服务的 endpoint
+func (a *CmqConfig) TopicEndpoint() string {
+	if len(a.topicEndpoint) == 0 {
+		return a.endpoint
+	}
+	return a.topicEndpoint
+}
+
+// action 使用的服务，ServiceQueue 或 ServiceTopic
+func serviceFor(action string) string {
+	if topicActions[action] {
+		return ServiceTopic
+	}
+	return ServiceQueue
+}
+
+// 服务的主 endpoint
+func (a *CmqConfig) serviceEndpoint(service string) string {
+	if service == ServiceTopic {
+		return a.TopicEndpoint()
+	}
+	return a.QueueEndpoint()
+}
+
+// action 应该发往的主 endpoint
+func (a *CmqConfig) endpointFor(action string) string {
+	return a.serviceEndpoint(serviceFor(action))
+}
@@ -0,0 +1,88 @@
+package cmq
+
+import (
+	"testing"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestDefaultEndpointResolver(t *testing.T) {
+	cases := []struct {
+		region, network, service, want string
+	}{
+		{"bj", NetworkPublic, ServiceQueue, "https://cmq-queue-bj.api.qcloud.com"},
+		{"gz", "", ServiceTopic, "https://cmq-topic-gz.api.qcloud.com"},
+		{"sh", NetworkInternal, ServiceQueue, "http://cmq-queue-sh.api.tencentyun.com"},
+		{"sh", "vpc", ServiceQueue, ""},
+		{"", NetworkPublic, ServiceQueue, ""},
+	}
+	for _, c := range cases {
+		if got := DefaultEndpointResolver(c.region, c.network, c.service); got != c.want {
+			t.Errorf("%s/%s/%s = %q, want %q", c.region, c.network, c.service, got, c.want)
+		}
+	}
+}
+
+func TestNewAccountRegion(t *testing.T) {
+	account, err := NewAccountRegion("bj", NetworkInternal, "id", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.QueueEndpoint() != "http://cmq-queue-bj.api.tencentyun.com" {
+		t.Errorf("queue endpoint = %s", account.QueueEndpoint())
+	}
+	if account.endpointFor(PublishMessage) != "http://cmq-topic-bj.api.tencentyun.com" {
+		t.Errorf("publish endpoint = %s", account.endpointFor(PublishMessage))
+	}
+
+	account.SetEndpointResolver(func(region, network, service string) string {
+		return "http://" + service + "." + region + ".cmq.internal"
+	})
+	if account.endpointFor(CreateQueue) != "http://queue.bj.cmq.internal" {
+		t.Errorf("create queue endpoint = %s", account.endpointFor(CreateQueue))
+	}
+}
+
+func TestNewAccountRegion_Invalid(t *testing.T) {
+	cases := []struct {
+		region  string
+		network string
+	}{
+		{"", NetworkPublic},
+		{"bj.evil.com/", NetworkPublic},
+		{"bj", ""},
+		{"bj", "vpc"},
+	}
+	for _, c := range cases {
+		if _, err := NewAccountRegion(c.region, c.network, "id", "key"); err == nil || err.Code != CMQError100 {
+			t.Errorf("NewAccountRegion(%q, %q) error = %v", c.region, c.network, err)
+		}
+	}
+}
+
+func TestCmqConfig_SeparateEndpoints(t *testing.T) {
+	queueServer := cmqtest.NewServer()
+	defer queueServer.Close()
+	topicServer := cmqtest.NewServer()
+	defer topicServer.Close()
+
+	account, err := NewAccountRegion("bj", NetworkPublic, "id", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account.SetQueueEndpoint(queueServer.URL)
+	account.SetTopicEndpoint(topicServer.URL)
+
+	if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := account.GetTopic("topic-a").PublishMessage("hello", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if calls := queueServer.Calls(); len(calls) != 1 || calls[0] != SendMessage {
+		t.Errorf("queue server calls = %v", calls)
+	}
+	if calls := topicServer.Calls(); len(calls) != 1 || calls[0] != PublishMessage {
+		t.Errorf("topic server calls = %v", calls)
+	}
+}
@@ -0,0 +1,114 @@
+package cmq
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+//当前的消息信封版本
+//新版本只会增加字段，旧版本的SDK解开新版本的信封时忽略不认识的字段
+const EnvelopeVersion = 1
+
+//常用的消息头
+const (
+	HeaderContentType   = "Content-Type"
+	HeaderCorrelationId = "Correlation-Id"
+	HeaderReplyTo       = "Reply-To"
+	HeaderSchemaVersion = "Schema-Version"
+)
+
+//信封以这个前缀开头，用来快速区分普通消息正文
+const envelopePrefix = `{"cmqEnvelope":`
+
+//消息信封，把消息头和消息正文一起放在 msgBody 中
+//CMQ 的消息只有字符串类型的 msgBody，信封用于携带链路追踪等附加信息
+type Envelope struct {
+	//信封版本
+	Version int `json:"cmqEnvelope"`
+	//消息头
+	Headers map[string]string `json:"headers,omitempty"`
+	//原始消息正文
+	Body string `json:"body"`
+}
+
+//把消息正文和消息头包装成信封，headers 为空时原样返回 body
+func WrapBody(body string, headers map[string]string) string {
+	if len(headers) == 0 {
+		return body
+	}
+	b, _ := json.Marshal(&Envelope{
+		Version: EnvelopeVersion,
+		Headers: headers,
+		Body:    body,
+	})
+	return string(b)
+}
+
+//解开信封，返回原始消息正文和消息头
+//不是信封格式的消息（比如不使用本SDK的生产者发送的消息）原样返回，ok 为 false
+func UnwrapBody(msgBody string) (body string, headers map[string]string, ok bool) {
+	if !strings.HasPrefix(msgBody, envelopePrefix) {
+		return msgBody, nil, false
+	}
+	var e Envelope
+	if err := json.Unmarshal([]byte(msgBody), &e); err != nil || e.Version < 1 {
+		return msgBody, nil, false
+	}
+	return e.Body, e.Headers, true
+}
+
+//给消息正文添加消息头，已经是信封格式的合并到原有消息头中
+func AddHeaders(msgBody string, headers map[string]string) string {
+	body, old, _ := UnwrapBody(msgBody)
+	if len(old) == 0 {
+		return WrapBody(body, headers)
+	}
+	merged := make(map[string]string, len(old)+len(headers))
+	for k, v := range old {
+		merged[k] = v
+	}
+	for k, v := range headers {
+		merged[k] = v
+	}
+	return WrapBody(body, merged)
+}
+
+//消息头 key 的值，没有时返回空字符串
+func (m *Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+//接收到的消息是信封格式时解开信封，把 MsgBody 还原为原始消息正文，
+//保存在 BlobStore 中、加密、压缩的消息正文自动读取、解密、解压
+//失败时保留原来的消息正文和消息头，错误放在 DecodeErr 中
+func (m *Message) unwrap(ctx context.Context, kp KeyProvider, blobs BlobStore) {
+	m.MsgBody, m.Headers, _ = UnwrapBody(m.MsgBody)
+	body, headers, err := decodeBody(ctx, m.MsgBody, m.Headers, kp, blobs)
+	if err != nil {
+		if de, ok := err.(*DecryptionError); ok {
+			de.MsgId = m.MsgId
+		}
+		log.Println("decode message error, msgId: " + m.MsgId + ", msg: " + err.Error())
+		m.DecodeErr = err
+		return
+	}
+	m.MsgBody, m.Headers = body, headers
+}
+
+//还原消息正文：从 BlobStore 读取大消息正文，再解密、解压
+func decodeBody(ctx context.Context, body string, headers map[string]string, kp KeyProvider, blobs BlobStore) (string, map[string]string, error) {
+	var err error
+	if len(headers[HeaderClaimCheck]) != 0 {
+		if body, err = claimBody(ctx, headers, blobs); err != nil {
+			return "", nil, err
+		}
+	}
+	if len(headers[HeaderEncryption]) != 0 {
+		if body, headers, err = DecryptBody(body, headers, kp); err != nil {
+			return "", nil, err
+		}
+	}
+	return DecompressBody(body, headers)
+}
@@ -0,0 +1,60 @@
+package cmq
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestWrapBody(t *testing.T) {
+	if WrapBody("plain", nil) != "plain" {
+		t.Error("body without headers should not be wrapped")
+	}
+
+	wrapped := AddHeaders(WrapBody(`{"a":"<b>"}`, map[string]string{"k1": "v1"}), map[string]string{"k2": "v2"})
+	body, headers, ok := UnwrapBody(wrapped)
+	if !ok || body != `{"a":"<b>"}` || headers["k1"] != "v1" || headers["k2"] != "v2" {
+		t.Errorf("UnwrapBody(%s) = %q %v %v", wrapped, body, headers, ok)
+	}
+
+	for _, plain := range []string{"", "hello", `{"cmqEnvelope":"x"}`, `{"cmqEnvelope":0,"body":"x"}`} {
+		if body, _, ok := UnwrapBody(plain); ok || body != plain {
+			t.Errorf("UnwrapBody(%q) should return the body untouched", plain)
+		}
+	}
+}
+
+func TestQueue_SendMessageWithHeaders(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	q := NewAccountDefault(s.URL, "id", "key").GetQueue("queue-a")
+
+	if _, err := q.SendMessageWithHeaders("hello", map[string]string{HeaderCorrelationId: "c-1"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	// 不使用本SDK的生产者发送的普通消息
+	s.Enqueue("queue-a", "plain")
+
+	msgs, err := q.BatchReceiveMessage(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].MsgBody != "hello" || msgs[0].Header(HeaderCorrelationId) != "c-1" {
+		t.Errorf("unexpected message %+v", msgs[0])
+	}
+	if msgs[1].MsgBody != "plain" || msgs[1].Headers != nil {
+		t.Errorf("unexpected plain message %+v", msgs[1])
+	}
+}
+
+func TestParseNotification_Headers(t *testing.T) {
+	body := WrapBody("hello", map[string]string{HeaderContentType: "text/plain"})
+	n, err := ParseNotification([]byte(`{"topicName":"topic-a","msgId":"msg-1","msgBody":` + strconv.Quote(body) + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.MsgBody != "hello" || n.Headers[HeaderContentType] != "text/plain" {
+		t.Errorf("unexpected notification %+v", n)
+	}
+}
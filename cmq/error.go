@@ -15,8 +15,12 @@ const (
 	CMQError1012		= syscall.Errno(1012)
 	//读取response body错误
 	CMQError1013		= syscall.Errno(1013)
+	//客户端限流
+	CMQError1014		= syscall.Errno(1014)
 	//JSON解析失败
 	CMQError102			= syscall.Errno(102)
+	//(服务端)队列中没有消息
+	CMQError7000		= syscall.Errno(7000)
 )
 
 var (
@@ -0,0 +1,300 @@
+package cmq
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	//连续失败多少次后熔断 endpoint
+	DefaultEndpointFailureThreshold = 3
+	//熔断后多久允许再次尝试
+	DefaultEndpointOpenTimeout = 30 * time.Second
+	//探测一个 endpoint 的超时时间
+	endpointProbeTimeout = 5 * time.Second
+)
+
+// 一个 endpoint 的健康状态
+type EndpointStatus struct {
+	Endpoint string
+	//服务：ServiceQueue 或 ServiceTopic
+	Service string
+	//优先级，数字越小越优先，构造函数和 SetQueueEndpoint/SetTopicEndpoint 设置的 endpoint 为 0
+	Priority int
+	//连续失败次数
+	Failures int
+	//最近一次失败的错误
+	LastError error
+	//熔断结束时间，为零值表示没有熔断
+	OpenUntil time.Time
+}
+
+// 熔断中，还不能发送请求
+func (s *EndpointStatus) Open(now time.Time) bool {
+	return now.Before(s.OpenUntil)
+}
+
+type backupEndpoint struct {
+	endpoint string
+	priority int
+}
+
+// 多个 endpoint 的健康状态，保存在 CmqConfig 上，所有 Client 共享
+type endpointHealth struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openTimeout      time.Duration
+	backups          map[string][]backupEndpoint
+	// 按 endpoint 记录的健康状态
+	status map[string]*EndpointStatus
+}
+
+func (h *endpointHealth) init() {
+	if h.status == nil {
+		h.failureThreshold = DefaultEndpointFailureThreshold
+		h.openTimeout = DefaultEndpointOpenTimeout
+		h.backups = map[string][]backupEndpoint{}
+		h.status = map[string]*EndpointStatus{}
+	}
+}
+
+// 添加队列服务的备用 endpoint，priority 数字越小越优先，主 endpoint 的优先级为 0
+// 主 endpoint 连续失败后熔断，请求自动切换到健康的备用 endpoint
+func (a *CmqConfig) AddQueueEndpoint(endpoint string, priority int) {
+	a.addEndpoint(ServiceQueue, endpoint, priority)
+}
+
+// 添加主题服务的备用 endpoint
+func (a *CmqConfig) AddTopicEndpoint(endpoint string, priority int) {
+	a.addEndpoint(ServiceTopic, endpoint, priority)
+}
+
+func (a *CmqConfig) addEndpoint(service, endpoint string, priority int) {
+	h := &a.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.init()
+	h.backups[service] = append(h.backups[service], backupEndpoint{endpoint: endpoint, priority: priority})
+}
+
+// 设置熔断参数：连续失败 failureThreshold 次后熔断 openTimeout，之后允许一次请求试探
+func (a *CmqConfig) SetCircuitBreaker(failureThreshold int, openTimeout time.Duration) {
+	h := &a.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.init()
+	if failureThreshold > 0 {
+		h.failureThreshold = failureThreshold
+	}
+	if openTimeout > 0 {
+		h.openTimeout = openTimeout
+	}
+}
+
+// 所有 endpoint 的健康状态，按服务和优先级排序
+func (a *CmqConfig) EndpointStatus() []EndpointStatus {
+	var res []EndpointStatus
+	for _, service := range []string{ServiceQueue, ServiceTopic} {
+		for _, s := range a.endpointCandidates(service) {
+			res = append(res, *s)
+		}
+	}
+	return res
+}
+
+// 服务的所有 endpoint，按优先级排序
+func (a *CmqConfig) endpointCandidates(service string) []*EndpointStatus {
+	primary := a.serviceEndpoint(service)
+	h := &a.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.init()
+	res := []*EndpointStatus{h.get(service, primary, 0)}
+	for _, b := range h.backups[service] {
+		if b.endpoint != primary {
+			res = append(res, h.get(service, b.endpoint, b.priority))
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Priority < res[j].Priority
+	})
+	return res
+}
+
+func (h *endpointHealth) get(service, endpoint string, priority int) *EndpointStatus {
+	s, ok := h.status[service+" "+endpoint]
+	if !ok {
+		s = &EndpointStatus{Endpoint: endpoint, Service: service}
+		h.status[service+" "+endpoint] = s
+	}
+	s.Priority = priority
+	return s
+}
+
+// 选择请求 action 依次尝试的 endpoint：没有熔断的 endpoint 按优先级和失败次数排序；
+// 全部熔断时返回最早结束熔断的一个
+func (a *CmqConfig) routeEndpoints(action string) []string {
+	candidates := a.endpointCandidates(serviceFor(action))
+	if len(candidates) == 1 {
+		return []string{candidates[0].Endpoint}
+	}
+
+	h := &a.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	var available []*EndpointStatus
+	earliest := candidates[0]
+	for _, s := range candidates {
+		if !s.Open(now) {
+			available = append(available, s)
+		} else if s.OpenUntil.Before(earliest.OpenUntil) {
+			earliest = s
+		}
+	}
+	if len(available) == 0 {
+		return []string{earliest.Endpoint}
+	}
+	sort.SliceStable(available, func(i, j int) bool {
+		if available[i].Priority != available[j].Priority {
+			return available[i].Priority < available[j].Priority
+		}
+		return available[i].Failures < available[j].Failures
+	})
+	res := make([]string, len(available))
+	for i, s := range available {
+		res[i] = s.Endpoint
+	}
+	return res
+}
+
+// 记录一次调用的结果
+func (a *CmqConfig) reportEndpoint(action, endpoint string, err *CMQError) {
+	service := serviceFor(action)
+	h := &a.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.init()
+	s, ok := h.status[service+" "+endpoint]
+	if !ok {
+		return
+	}
+	if err == nil {
+		s.Failures = 0
+		s.OpenUntil = time.Time{}
+		return
+	}
+	s.Failures++
+	s.LastError = err
+	if s.Failures >= h.failureThreshold {
+		s.OpenUntil = time.Now().Add(h.openTimeout)
+	}
+}
+
+// 请求没有到达服务端或没有收到响应，计入 endpoint 的失败次数
+func isEndpointError(err *CMQError) bool {
+	return err.Code == CMQError1011 || err.Code == CMQError1012 || err.Code == CMQError1013
+}
+
+// 服务端内部错误的错误码
+const codeServerInternal = 6000
+
+// 是否是临时错误：请求没有到达服务端、没有收到响应、被客户端限流或服务端内部错误，稍后重试可能成功
+// 服务端返回的其他错误（比如队列不存在、参数错误）重试也不会成功
+func IsTemporary(err *CMQError) bool {
+	return isEndpointError(err) || err.Code == CMQError1014 || err.Code == codeServerInternal
+}
+
+// 重复执行没有副作用的 action
+var idempotentActions = map[string]bool{
+	GetQueueAttributes:        true,
+	ListQueue:                 true,
+	GetTopicAttributes:        true,
+	ListTopic:                 true,
+	GetSubscriptionAttributes: true,
+	ListSubscriptionByTopic:   true,
+}
+
+// 请求一定没有到达服务端：创建请求失败、域名解析失败或建立连接失败
+func isUnsentError(err *CMQError) bool {
+	if err.Code == CMQError1011 {
+		return true
+	}
+	if err.Code != CMQError1012 {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err.Err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err.Err, &dnsErr)
+}
+
+// 是否可以换一个 endpoint 重试
+// 请求可能已经被服务端处理时（比如读取响应失败），重试会重复发送消息或丢失已经接收的消息，
+// 只有没有副作用的 action 才重试
+func canFailover(action string, err *CMQError) bool {
+	return isUnsentError(err) || (isEndpointError(err) && idempotentActions[action])
+}
+
+// 探测一次所有熔断中的 endpoint，能收到 HTTP 响应即认为恢复
+func (a *CmqConfig) ProbeEndpoints(ctx context.Context) {
+	h := &a.health
+	h.mu.Lock()
+	now := time.Now()
+	var open []*EndpointStatus
+	for _, s := range h.status {
+		if s.Open(now) {
+			open = append(open, s)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, s := range open {
+		err := probeEndpoint(ctx, s.Endpoint+a.path)
+		if err != nil {
+			log.Println("probe endpoint " + s.Endpoint + " error, msg: " + err.Error())
+			continue
+		}
+		h.mu.Lock()
+		s.Failures = 0
+		s.OpenUntil = time.Time{}
+		h.mu.Unlock()
+	}
+}
+
+// 按 interval 定时探测熔断中的 endpoint，直到 ctx 结束
+func (a *CmqConfig) RunEndpointProber(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			a.ProbeEndpoints(ctx)
+		}
+	}
+}
+
+func probeEndpoint(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, endpointProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,130 @@
+package cmq
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+// 返回一个已经关闭的地址，请求会连接失败
+func deadEndpoint() string {
+	s := httptest.NewServer(nil)
+	s.Close()
+	return s.URL
+}
+
+func TestCmqConfig_Failover(t *testing.T) {
+	backup := cmqtest.NewServer()
+	defer backup.Close()
+
+	account := NewAccountDefault(deadEndpoint(), "id", "key")
+	account.AddQueueEndpoint(backup.URL, 1)
+	account.SetCircuitBreaker(2, time.Minute)
+	q := account.GetQueue("queue-a")
+
+	for i := 0; i < 3; i++ {
+		if _, err := q.SendMessage("hello", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(backup.Messages("queue-a")); n != 3 {
+		t.Errorf("backup received %d messages", n)
+	}
+
+	status := account.EndpointStatus()
+	if status[0].Priority != 0 || !status[0].Open(time.Now()) || status[0].Failures != 2 {
+		t.Errorf("primary should be open after 2 failures, got %+v", status[0])
+	}
+	if status[1].Endpoint != backup.URL || status[1].Failures != 0 {
+		t.Errorf("unexpected backup status %+v", status[1])
+	}
+}
+
+func TestCmqConfig_ProbeEndpoints(t *testing.T) {
+	primary := cmqtest.NewServer()
+	defer primary.Close()
+	backup := cmqtest.NewServer()
+	defer backup.Close()
+
+	account := NewAccountDefault(primary.URL, "id", "key")
+	account.AddQueueEndpoint(backup.URL, 1)
+	account.SetCircuitBreaker(1, time.Hour)
+	account.routeEndpoints(SendMessage)
+	account.reportEndpoint(SendMessage, primary.URL, NewCMQError(CMQError1012, nil))
+
+	if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.Calls()) != 0 || len(backup.Calls()) != 1 {
+		t.Fatalf("open primary should be skipped, primary %v, backup %v", primary.Calls(), backup.Calls())
+	}
+
+	account.ProbeEndpoints(context.Background())
+	if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err != nil {
+		t.Fatal(err)
+	}
+	// 探测请求不带 Action
+	if calls := primary.Calls(); len(calls) != 2 || calls[1] != SendMessage {
+		t.Errorf("primary should be used again after probing, calls %v", primary.Calls())
+	}
+}
+
+func TestCmqConfig_NoFailoverAfterSent(t *testing.T) {
+	// 读取请求后断开连接，请求可能已经被处理
+	var calls int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer primary.Close()
+	backup := cmqtest.NewServer()
+	defer backup.Close()
+
+	account := NewAccountDefault(primary.URL, "id", "key")
+	account.AddQueueEndpoint(backup.URL, 1)
+	q := account.GetQueue("queue-a")
+
+	if _, err := q.SendMessage("hello", 0); err == nil {
+		t.Fatal("SendMessage should fail")
+	}
+	if len(backup.Calls()) != 0 {
+		t.Errorf("SendMessage should not be sent again to backup, calls %v", backup.Calls())
+	}
+	if _, err := q.GetQueueAttributes(); err != nil {
+		t.Fatalf("idempotent action should fail over, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("primary received %d requests, want 2", n)
+	}
+}
+
+func TestCmqConfig_CancelNotReported(t *testing.T) {
+	block := make(chan struct{})
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer primary.Close()
+	defer close(block)
+
+	account := NewAccountDefault(primary.URL, "id", "key")
+	account.SetCircuitBreaker(1, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := account.GetQueue("queue-a").WithContext(ctx).ReceiveMessage(10); err == nil {
+		t.Fatal("ReceiveMessage should fail")
+	}
+	for _, s := range account.EndpointStatus() {
+		if s.Failures != 0 || s.Open(time.Now()) {
+			t.Errorf("cancelled call should not be reported, got %+v", s)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package cmq
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	//生产者设置的幂等键的消息头，没有时使用 MsgId
+	HeaderIdempotencyKey = "Idempotency-Key"
+	//缺省的去重时间窗口
+	DefaultIdempotencyWindow = 24 * time.Hour
+	//缺省的处理租约，处理中的消息超过这个时间没有完成，认为处理者已经退出
+	DefaultIdempotencyLease = 5 * time.Minute
+	//内存去重存储缺省保存的键数
+	DefaultIdempotencyCapacity = 100000
+)
+
+// 另一个消费者正在处理同一条消息
+var ErrMessageInProgress = errors.New("message is being processed")
+
+// 占用幂等键的结果
+type ClaimResult int
+
+const (
+	//占用成功，可以处理
+	ClaimAcquired ClaimResult = iota
+	//已经处理过
+	ClaimCompleted
+	//正在被处理
+	ClaimInProgress
+)
+
+// 幂等键存储
+type IdempotencyStore interface {
+	//占用 key，lease 之后没有 Complete 或 Release 则自动释放
+	Claim(ctx context.Context, key string, lease time.Duration) (ClaimResult, error)
+	//key 处理完成，window 时间内再次 Claim 返回 ClaimCompleted
+	Complete(ctx context.Context, key string, window time.Duration) error
+	//处理失败，释放 key，允许再次处理
+	Release(ctx context.Context, key string) error
+}
+
+// 消息的幂等键：生产者设置的 Idempotency-Key 消息头，没有时为 MsgId
+func IdempotencyKey(m *Message) string {
+	if key := m.Header(HeaderIdempotencyKey); len(key) > 0 {
+		return key
+	}
+	if _, headers, ok := UnwrapBody(m.MsgBody); ok {
+		if key := headers[HeaderIdempotencyKey]; len(key) > 0 {
+			return key
+		}
+	}
+	return m.MsgId
+}
+
+// 消息去重，同一个幂等键在时间窗口内只成功处理一次
+//
+//	d := cmq.NewDeduplicator(cmq.NewMemoryIdempotencyStore(0), time.Hour)
+//	consumer.Use(d.Middleware())
+type Deduplicator struct {
+	store  IdempotencyStore
+	window time.Duration
+	lease  time.Duration
+	key    func(m *Message) string
+}
+
+// 创建去重器，window 小于等于 0 时使用 DefaultIdempotencyWindow
+func NewDeduplicator(store IdempotencyStore, window time.Duration) *Deduplicator {
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	return &Deduplicator{
+		store:  store,
+		window: window,
+		lease:  DefaultIdempotencyLease,
+		key:    IdempotencyKey,
+	}
+}
+
+// 设置处理租约，应该大于处理一条消息的最长时间
+func (d *Deduplicator) SetLease(lease time.Duration) {
+	if lease > 0 {
+		d.lease = lease
+	}
+}
+
+// 设置计算幂等键的函数，返回空字符串的消息不去重
+func (d *Deduplicator) SetKeyFunc(key func(m *Message) string) {
+	d.key = key
+}
+
+// Consumer 的 Handler 中间件：
+// 已经处理过的消息直接返回成功，由消费者删除；正在被处理的消息返回 ErrMessageInProgress，稍后重新可见
+func (d *Deduplicator) Middleware() HandlerMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, m *Message) error {
+			key := d.key(m)
+			if len(key) == 0 {
+				return next(ctx, m)
+			}
+			res, err := d.store.Claim(ctx, key, d.lease)
+			if err != nil {
+				return err
+			}
+			switch res {
+			case ClaimCompleted:
+				log.Println("skip duplicate message, msgId: " + m.MsgId + ", key: " + key)
+				return nil
+			case ClaimInProgress:
+				return ErrMessageInProgress
+			}
+
+			if err := next(ctx, m); err != nil {
+				if rerr := d.store.Release(ctx, key); rerr != nil {
+					log.Println("release idempotency key error, key: " + key + ", msg: " + rerr.Error())
+				}
+				return err
+			}
+			if err := d.store.Complete(ctx, key, d.window); err != nil {
+				log.Println("complete idempotency key error, key: " + key + ", msg: " + err.Error())
+			}
+			return nil
+		}
+	}
+}
+
+type idempotencyEntry struct {
+	key    string
+	done   bool
+	expire time.Time
+}
+
+// 内存中的幂等键存储，超过容量时淘汰最久没有使用的键
+// 只能对同一个进程内的消费者去重
+type MemoryIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+	now      func() time.Time
+}
+
+// 创建内存存储，capacity 小于等于 0 时使用 DefaultIdempotencyCapacity
+func NewMemoryIdempotencyStore(capacity int) *MemoryIdempotencyStore {
+	if capacity <= 0 {
+		capacity = DefaultIdempotencyCapacity
+	}
+	return &MemoryIdempotencyStore{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryIdempotencyStore) Claim(ctx context.Context, key string, lease time.Duration) (ClaimResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*idempotencyEntry)
+		if now.Before(e.expire) {
+			s.ll.MoveToFront(el)
+			if e.done {
+				return ClaimCompleted, nil
+			}
+			return ClaimInProgress, nil
+		}
+		e.done = false
+		e.expire = now.Add(lease)
+		s.ll.MoveToFront(el)
+		return ClaimAcquired, nil
+	}
+	s.items[key] = s.ll.PushFront(&idempotencyEntry{key: key, expire: now.Add(lease)})
+	s.evict()
+	return ClaimAcquired, nil
+}
+
+func (s *MemoryIdempotencyStore) evict() {
+	for s.ll.Len() > s.capacity {
+		el := s.ll.Back()
+		s.ll.Remove(el)
+		delete(s.items, el.Value.(*idempotencyEntry).key)
+	}
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		el = s.ll.PushFront(&idempotencyEntry{key: key})
+		s.items[key] = el
+	}
+	e := el.Value.(*idempotencyEntry)
+	e.done = true
+	e.expire = s.now().Add(window)
+	s.ll.MoveToFront(el)
+	s.evict()
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.ll.Remove(el)
+		delete(s.items, key)
+	}
+	return nil
+}
+
+// 保存的键数
+func (s *MemoryIdempotencyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
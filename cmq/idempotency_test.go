@@ -0,0 +1,87 @@
+package cmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeduplicator_Middleware(t *testing.T) {
+	calls := 0
+	fail := true
+	handler := NewDeduplicator(NewMemoryIdempotencyStore(0), time.Hour).Middleware()(func(ctx context.Context, m *Message) error {
+		calls++
+		if fail {
+			return errors.New("fail")
+		}
+		return nil
+	})
+	ctx := context.Background()
+	m := &Message{MsgId: "msg-1", MsgBody: "hello"}
+
+	if err := handler(ctx, m); err == nil {
+		t.Fatal("want handler error")
+	}
+	// 处理失败后可以再次处理
+	fail = false
+	if err := handler(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+
+	// 幂等键相同的不同消息
+	other := &Message{MsgId: "msg-2", MsgBody: WrapBody("hello", map[string]string{HeaderIdempotencyKey: "order-1"})}
+	resent := &Message{MsgId: "msg-3", MsgBody: WrapBody("hello", map[string]string{HeaderIdempotencyKey: "order-1"})}
+	handler(ctx, other)
+	handler(ctx, resent)
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+}
+
+func TestDeduplicator_InProgress(t *testing.T) {
+	store := NewMemoryIdempotencyStore(0)
+	store.Claim(context.Background(), "msg-1", time.Minute)
+	handler := NewDeduplicator(store, time.Hour).Middleware()(func(ctx context.Context, m *Message) error {
+		t.Error("handler should not be called")
+		return nil
+	})
+	if err := handler(context.Background(), &Message{MsgId: "msg-1"}); err != ErrMessageInProgress {
+		t.Errorf("handler() = %v, want ErrMessageInProgress", err)
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := NewMemoryIdempotencyStore(2)
+	s.now = func() time.Time { return now }
+
+	s.Claim(ctx, "a", time.Minute)
+	s.Complete(ctx, "a", time.Hour)
+	if res, _ := s.Claim(ctx, "a", time.Minute); res != ClaimCompleted {
+		t.Errorf("Claim(a) = %v, want ClaimCompleted", res)
+	}
+
+	// 过期后可以再次占用
+	now = now.Add(2 * time.Hour)
+	if res, _ := s.Claim(ctx, "a", time.Minute); res != ClaimAcquired {
+		t.Errorf("Claim(a) = %v after window, want ClaimAcquired", res)
+	}
+
+	// 超过容量淘汰最久没有使用的键
+	s.Claim(ctx, "b", time.Minute)
+	s.Claim(ctx, "c", time.Minute)
+	if s.Len() != 2 {
+		t.Errorf("Len() = %d", s.Len())
+	}
+	if res, _ := s.Claim(ctx, "a", time.Minute); res != ClaimAcquired {
+		t.Errorf("Claim(a) = %v after eviction, want ClaimAcquired", res)
+	}
+}
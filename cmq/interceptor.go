@@ -0,0 +1,61 @@
+package cmq
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+//调用CMQ API，返回原始的响应内容
+type Invoker func(ctx context.Context, action string, params map[string]interface{}) (string, *CMQError)
+
+//拦截器，在各个方法组装好参数之后、签名发送之前执行
+//可以查看和修改 action、params，调用 invoker 继续执行，也可以不调用 invoker 直接返回结果或错误；
+//invoker 返回之后可以查看和修改原始响应和错误。
+//params 中还没有 Action、Nonce、Timestamp、Signature 等公共参数。
+type Interceptor func(ctx context.Context, action string, params map[string]interface{}, invoker Invoker) (string, *CMQError)
+
+//添加拦截器，先添加的在外层，先执行
+//对通过这个 CmqConfig 获取的所有 Queue、Topic、Subscription、Cmq 生效，应在发起调用之前添加
+func (a *CmqConfig) AddInterceptor(interceptors ...Interceptor) {
+	a.interceptors = append(a.interceptors, interceptors...)
+}
+
+//把多个拦截器串成一个，第一个在最外层
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	return func(ctx context.Context, action string, params map[string]interface{}, invoker Invoker) (string, *CMQError) {
+		return chain(interceptors, invoker)(ctx, action, params)
+	}
+}
+
+func chain(interceptors []Interceptor, invoker Invoker) Invoker {
+	if len(interceptors) == 0 {
+		return invoker
+	}
+	next := chain(interceptors[1:], invoker)
+	return func(ctx context.Context, action string, params map[string]interface{}) (string, *CMQError) {
+		return interceptors[0](ctx, action, params, next)
+	}
+}
+
+type retryCounterKey struct{}
+
+//返回带重试计数的 ctx，拦截器用这个 ctx 调用 invoker 后，用 Retries 读取 invoker 内部的重试次数
+//（换 endpoint 重试、时钟偏差修正后重试）
+func WithRetryCounter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryCounterKey{}, new(int32))
+}
+
+//通过 WithRetryCounter 返回的 ctx 发起的调用的重试次数
+func Retries(ctx context.Context) int {
+	if n, ok := ctx.Value(retryCounterKey{}).(*int32); ok {
+		return int(atomic.LoadInt32(n))
+	}
+	return 0
+}
+
+//记录一次重试
+func countRetry(ctx context.Context) {
+	if n, ok := ctx.Value(retryCounterKey{}).(*int32); ok {
+		atomic.AddInt32(n, 1)
+	}
+}
@@ -0,0 +1,60 @@
+package cmq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCmqConfig_AddInterceptor(t *testing.T) {
+	var tenant string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		tenant = r.PostForm.Get("tenant")
+		w.Write([]byte(`{"code":0,"message":"","requestId":"r1","msgId":"m1"}`))
+	}))
+	defer server.Close()
+
+	account := NewAccountDefault(server.URL, "id", "key")
+	var calls []string
+	account.AddInterceptor(
+		func(ctx context.Context, action string, params map[string]interface{}, invoker Invoker) (string, *CMQError) {
+			calls = append(calls, "outer:"+action)
+			r, err := invoker(ctx, action, params)
+			calls = append(calls, "outer:"+r)
+			return r, err
+		},
+		func(ctx context.Context, action string, params map[string]interface{}, invoker Invoker) (string, *CMQError) {
+			calls = append(calls, "inner:"+action)
+			params["tenant"] = "t1"
+			return invoker(ctx, action, params)
+		},
+	)
+
+	msgId, err := account.GetQueue("queue-a").SendMessage("hello", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgId != "m1" || tenant != "t1" {
+		t.Errorf("msgId = %q, tenant = %q", msgId, tenant)
+	}
+	want := []string{"outer:SendMessage", "inner:SendMessage", `outer:{"code":0,"message":"","requestId":"r1","msgId":"m1"}`}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v", calls)
+	}
+}
+
+func TestCmqConfig_AddInterceptorShortCircuit(t *testing.T) {
+	account := NewAccountDefault("http://127.0.0.1:1", "id", "key")
+	account.AddInterceptor(func(ctx context.Context, action string, params map[string]interface{}, invoker Invoker) (string, *CMQError) {
+		return "", NewCMQOpError(CMQError1012, errors.New("injected"), action)
+	})
+
+	_, err := account.GetQueue("queue-a").SendMessage("hello", 0)
+	if err == nil || err.Code != CMQError1012 || err.Op != SendMessage {
+		t.Errorf("want injected error, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package cmq
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// SDK 的监控指标
+// resource 为队列名或主题名；code 为 0 表示成功，其他为 CMQ 错误码或 CMQError 的 Code
+// Prometheus 的实现见 cmqprom 包
+type Metrics interface {
+	//一次 CMQ API 调用的耗时和结果
+	ObserveCall(action, resource string, code int, d time.Duration)
+	//一次 CMQ API 调用的重试
+	IncRetry(action, resource string)
+	//发送成功的消息数
+	AddSent(resource string, n int)
+	//接收到的消息数
+	AddReceived(resource string, n int)
+	//删除成功的消息数
+	AddDeleted(resource string, n int)
+	//一条消息的处理耗时，err 为处理函数返回的错误
+	ObserveHandle(resource string, err error, d time.Duration)
+	//正在处理的消息数增减
+	AddInFlight(resource string, delta int)
+}
+
+// 不记录任何指标
+type NopMetrics struct{}
+
+func (NopMetrics) ObserveCall(action, resource string, code int, d time.Duration) {}
+func (NopMetrics) IncRetry(action, resource string)                               {}
+func (NopMetrics) AddSent(resource string, n int)                                 {}
+func (NopMetrics) AddReceived(resource string, n int)                             {}
+func (NopMetrics) AddDeleted(resource string, n int)                              {}
+func (NopMetrics) ObserveHandle(resource string, err error, d time.Duration)      {}
+func (NopMetrics) AddInFlight(resource string, delta int)                         {}
+
+// 记录 CMQ API 调用的耗时和结果码，对通过这个 CmqConfig 发起的所有调用生效
+func (a *CmqConfig) SetMetrics(m Metrics) {
+	a.metrics = m
+}
+
+// 记录调用耗时和结果码，在所有拦截器之外
+func observeCall(m Metrics, ctx context.Context, action string, params map[string]interface{}, invoker Invoker) (string, *CMQError) {
+	resource := resourceName(params)
+	start := time.Now()
+	result, err := invoker(ctx, action, params)
+	code := 0
+	if err != nil {
+		code = int(err.Code)
+	} else {
+		var res struct {
+			Code int `json:"code"`
+		}
+		if json.Unmarshal([]byte(result), &res) != nil {
+			code = int(CMQError102)
+		} else {
+			code = res.Code
+		}
+	}
+	m.ObserveCall(action, resource, code, time.Since(start))
+	return result, err
+}
+
+// 请求参数中的队列名或主题名
+func resourceName(params map[string]interface{}) string {
+	if qn, ok := params["queueName"].(string); ok {
+		return qn
+	}
+	if tn, ok := params["topicName"].(string); ok {
+		return tn
+	}
+	return ""
+}
@@ -0,0 +1,309 @@
+package cmq
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	//监控的资源类型
+	ResourceQueue        = "queue"
+	ResourceTopic        = "topic"
+	ResourceSubscription = "subscription"
+)
+
+// 一个队列、主题或订阅的消息积压情况
+type Backlog struct {
+	//资源类型：queue、topic、subscription
+	Kind string
+	//队列名、主题名，订阅为 主题名/订阅名
+	Name string
+	//可以被消费的消息数；主题为 msgCount，订阅为待投递的消息数
+	ActiveMsgNum int
+	//已被接收还没有删除的消息数，只对队列有效
+	InactiveMsgNum int
+	//延时消息数，只对队列有效
+	DelayMsgNum int
+	//最早的未消费消息已经等待的时间，只对队列有效
+	Age time.Duration
+	//积压消息数每秒的增长量，第一次采集时为 0
+	GrowthRate float64
+	//采集时间
+	Time time.Time
+}
+
+// 积压的消息总数
+func (b *Backlog) Total() int {
+	return b.ActiveMsgNum + b.InactiveMsgNum + b.DelayMsgNum
+}
+
+// 积压监控指标，cmqprom.Metrics 实现了这个接口
+type BacklogMetrics interface {
+	SetBacklog(b *Backlog)
+	//资源不再监控（比如队列已删除）时删除它的指标
+	DeleteBacklog(kind, name string)
+}
+
+type threshold struct {
+	check    func(b *Backlog) bool
+	callback func(b *Backlog)
+	// 已经触发过的资源，恢复正常后再次超过阈值才会重新触发
+	fired map[string]bool
+}
+
+// 定时采集队列、主题、订阅的属性，计算积压时长和增长速度
+type Monitor struct {
+	account       *CmqConfig
+	interval      time.Duration
+	queues        []string
+	allQueues     bool
+	topics        []string
+	subscriptions [][2]string
+	thresholds    []*threshold
+	metrics       BacklogMetrics
+	onPoll        func(res []*Backlog)
+
+	mu   sync.Mutex
+	last map[string]*Backlog
+}
+
+// 创建监控，interval 为 Run 的采集间隔，只调用 Poll 时可以为 0
+func (a *CmqConfig) NewMonitor(interval time.Duration) *Monitor {
+	return &Monitor{
+		account:  a,
+		interval: interval,
+		last:     map[string]*Backlog{},
+	}
+}
+
+// 监控队列
+func (m *Monitor) AddQueue(queueNames ...string) {
+	m.queues = append(m.queues, queueNames...)
+}
+
+// 监控帐号下的所有队列，每次采集时通过 ListQueue 获取队列列表
+func (m *Monitor) AddAllQueues() {
+	m.allQueues = true
+}
+
+// 监控主题
+func (m *Monitor) AddTopic(topicNames ...string) {
+	m.topics = append(m.topics, topicNames...)
+}
+
+// 监控订阅
+func (m *Monitor) AddSubscription(topicName, subscriptionName string) {
+	m.subscriptions = append(m.subscriptions, [2]string{topicName, subscriptionName})
+}
+
+// 设置积压监控指标
+func (m *Monitor) SetMetrics(metrics BacklogMetrics) {
+	m.metrics = metrics
+}
+
+// 添加阈值回调，check 返回 true 表示超过阈值
+// 同一个资源超过阈值时只回调一次，check 返回 false 之后再次超过阈值才会重新回调
+func (m *Monitor) OnThreshold(check func(b *Backlog) bool, callback func(b *Backlog)) {
+	m.thresholds = append(m.thresholds, &threshold{
+		check:    check,
+		callback: callback,
+		fired:    map[string]bool{},
+	})
+}
+
+// 设置 Run 每次采集完成后的回调，res 为本次采集成功的资源
+func (m *Monitor) OnPoll(callback func(res []*Backlog)) {
+	m.onPoll = callback
+}
+
+// 按 interval 定时采集，直到 ctx 结束；采集错误只记录日志。interval 不大于 0 时返回错误，这时只能调用 Poll
+func (m *Monitor) Run(ctx context.Context) error {
+	if m.interval <= 0 {
+		return NewCMQError(CMQError100, invalid("interval", m.interval, "must be positive"))
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		res, err := m.Poll(ctx)
+		if err != nil {
+			log.Println("poll backlog error, msg: " + err.Error())
+		}
+		if m.onPoll != nil {
+			m.onPoll(res)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// 采集一次，返回所有资源的积压情况
+// 某个资源采集失败时继续采集其他资源，返回最后一个错误；不再监控的资源（比如已删除的队列）的历史数据会被清理
+func (m *Monitor) Poll(ctx context.Context) ([]*Backlog, *CMQError) {
+	var res []*Backlog
+	var lastErr *CMQError
+	collect := func(b *Backlog, err *CMQError) {
+		if err != nil {
+			lastErr = err
+			return
+		}
+		m.update(b)
+		res = append(res, b)
+	}
+
+	queues := m.queues
+	listed := true
+	if m.allQueues {
+		all, err := m.account.GetCmq().WithContext(ctx).ListAllQueues("")
+		if err != nil {
+			lastErr = err
+			listed = false
+		} else {
+			queues = mergeNames(queues, all)
+		}
+	}
+	monitored := map[string]bool{}
+	for _, qn := range queues {
+		monitored[backlogKey(ResourceQueue, qn)] = true
+		collect(m.queueBacklog(ctx, qn))
+	}
+	for _, tn := range m.topics {
+		monitored[backlogKey(ResourceTopic, tn)] = true
+		collect(m.topicBacklog(ctx, tn))
+	}
+	for _, s := range m.subscriptions {
+		monitored[backlogKey(ResourceSubscription, s[0]+"/"+s[1])] = true
+		collect(m.subscriptionBacklog(ctx, s[0], s[1]))
+	}
+	// 队列列表获取失败时不知道哪些队列已经删除，下次再清理
+	if listed {
+		m.prune(monitored)
+	}
+	return res, lastErr
+}
+
+func backlogKey(kind, name string) string {
+	return kind + ":" + name
+}
+
+// 删除不在 monitored 中的资源的历史数据、阈值状态和指标
+func (m *Monitor) prune(monitored map[string]bool) {
+	var removed []*Backlog
+	m.mu.Lock()
+	for key, b := range m.last {
+		if !monitored[key] {
+			delete(m.last, key)
+			removed = append(removed, b)
+		}
+	}
+	for _, t := range m.thresholds {
+		for key := range t.fired {
+			if !monitored[key] {
+				delete(t.fired, key)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	if m.metrics != nil {
+		for _, b := range removed {
+			m.metrics.DeleteBacklog(b.Kind, b.Name)
+		}
+	}
+}
+
+func (m *Monitor) queueBacklog(ctx context.Context, queueName string) (*Backlog, *CMQError) {
+	meta, err := m.account.GetQueue(queueName).WithContext(ctx).GetQueueAttributes()
+	if err != nil {
+		return nil, err
+	}
+	b := &Backlog{
+		Kind:           ResourceQueue,
+		Name:           queueName,
+		ActiveMsgNum:   meta.ActiveMsgNum(),
+		InactiveMsgNum: meta.InactiveMsgNum(),
+		DelayMsgNum:    meta.DelayMsgNum(),
+		Time:           time.Now(),
+	}
+	if meta.MinMsgTime() > 0 && b.Total() > 0 {
+		b.Age = b.Time.Sub(time.Unix(int64(meta.MinMsgTime()), 0))
+		if b.Age < 0 {
+			b.Age = 0
+		}
+	}
+	return b, nil
+}
+
+func (m *Monitor) topicBacklog(ctx context.Context, topicName string) (*Backlog, *CMQError) {
+	meta, err := m.account.GetTopic(topicName).WithContext(ctx).GetTopicAttributes()
+	if err != nil {
+		return nil, err
+	}
+	return &Backlog{
+		Kind:         ResourceTopic,
+		Name:         topicName,
+		ActiveMsgNum: meta.MsgCount(),
+		Time:         time.Now(),
+	}, nil
+}
+
+func (m *Monitor) subscriptionBacklog(ctx context.Context, topicName, subscriptionName string) (*Backlog, *CMQError) {
+	meta, err := m.account.GetSubscription(topicName, subscriptionName).WithContext(ctx).GetSubscriptionAttributes()
+	if err != nil {
+		return nil, err
+	}
+	return &Backlog{
+		Kind:         ResourceSubscription,
+		Name:         topicName + "/" + subscriptionName,
+		ActiveMsgNum: meta.MsgCount,
+		Time:         time.Now(),
+	}, nil
+}
+
+// 计算增长速度，更新指标并检查阈值
+func (m *Monitor) update(b *Backlog) {
+	key := backlogKey(b.Kind, b.Name)
+	var callbacks []func(b *Backlog)
+	m.mu.Lock()
+	if prev, ok := m.last[key]; ok {
+		if elapsed := b.Time.Sub(prev.Time).Seconds(); elapsed > 0 {
+			b.GrowthRate = float64(b.Total()-prev.Total()) / elapsed
+		}
+	}
+	m.last[key] = b
+	for _, t := range m.thresholds {
+		if !t.check(b) {
+			delete(t.fired, key)
+			continue
+		}
+		if !t.fired[key] {
+			t.fired[key] = true
+			callbacks = append(callbacks, t.callback)
+		}
+	}
+	m.mu.Unlock()
+
+	if m.metrics != nil {
+		m.metrics.SetBacklog(b)
+	}
+	for _, cb := range callbacks {
+		cb(b)
+	}
+}
+
+// 合并两个名称列表并去重
+func mergeNames(a, b []string) []string {
+	seen := map[string]bool{}
+	var res []string
+	for _, n := range append(append([]string{}, a...), b...) {
+		if !seen[n] {
+			seen[n] = true
+			res = append(res, n)
+		}
+	}
+	return res
+}
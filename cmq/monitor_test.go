@@ -0,0 +1,138 @@
+package cmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestMonitor_Poll(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	s.Enqueue("queue-a", "1")
+	s.Enqueue("queue-b", "1")
+
+	m := account.NewMonitor(time.Minute)
+	m.AddQueue("queue-a")
+	m.AddAllQueues()
+	var fired []string
+	m.OnThreshold(func(b *Backlog) bool { return b.Total() >= 2 }, func(b *Backlog) {
+		fired = append(fired, b.Name)
+	})
+
+	res, err := m.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Name != "queue-a" || res[1].Name != "queue-b" {
+		t.Fatalf("unexpected backlogs %+v", res)
+	}
+	if res[0].ActiveMsgNum != 1 || res[0].GrowthRate != 0 {
+		t.Errorf("unexpected backlog %+v", res[0])
+	}
+
+	s.Enqueue("queue-a", "2")
+	s.Enqueue("queue-a", "3")
+	res, _ = m.Poll(context.Background())
+	if res[0].Total() != 3 || res[0].GrowthRate <= 0 {
+		t.Errorf("unexpected backlog %+v", res[0])
+	}
+	m.Poll(context.Background())
+	if len(fired) != 1 || fired[0] != "queue-a" {
+		t.Errorf("threshold should fire once for queue-a, got %v", fired)
+	}
+}
+
+func TestMonitor_PollError(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+
+	m := account.NewMonitor(time.Minute)
+	m.AddQueue("queue-a", "queue-b")
+	s.FailNext(GetQueueAttributes, 4440)
+	res, err := m.Poll(context.Background())
+	if err == nil {
+		t.Fatal("want injected error")
+	}
+	if len(res) != 1 || res[0].Name != "queue-b" {
+		t.Errorf("other queues should still be polled, got %+v", res)
+	}
+}
+
+func TestMonitor_PruneRemoved(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	s.Enqueue("queue-a", "1")
+	s.Enqueue("queue-b", "1")
+
+	m := account.NewMonitor(time.Minute)
+	m.AddAllQueues()
+	metrics := &backlogRecorder{}
+	m.SetMetrics(metrics)
+	fired := 0
+	m.OnThreshold(func(b *Backlog) bool { return b.Total() >= 1 }, func(b *Backlog) {
+		fired++
+	})
+	m.Poll(context.Background())
+	if len(m.last) != 2 || fired != 2 {
+		t.Fatalf("last = %d, fired = %d, want 2", len(m.last), fired)
+	}
+
+	if err := account.GetCmq().DeleteQueue("queue-b"); err != nil {
+		t.Fatal(err)
+	}
+	m.Poll(context.Background())
+	if _, ok := m.last[backlogKey(ResourceQueue, "queue-b")]; ok || len(m.last) != 1 {
+		t.Errorf("deleted queue should be pruned, last = %v", m.last)
+	}
+	if len(m.thresholds[0].fired) != 1 {
+		t.Errorf("threshold state of deleted queue should be pruned, fired = %v", m.thresholds[0].fired)
+	}
+	if len(metrics.deleted) != 1 || metrics.deleted[0] != "queue:queue-b" {
+		t.Errorf("metrics of deleted queue should be deleted, got %v", metrics.deleted)
+	}
+}
+
+type backlogRecorder struct {
+	deleted []string
+}
+
+func (r *backlogRecorder) SetBacklog(b *Backlog) {}
+
+func (r *backlogRecorder) DeleteBacklog(kind, name string) {
+	r.deleted = append(r.deleted, backlogKey(kind, name))
+}
+
+func TestMonitor_OnPoll(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	s.Enqueue("queue-a", "1")
+
+	m := account.NewMonitor(time.Hour)
+	m.AddQueue("queue-a")
+	ctx, cancel := context.WithCancel(context.Background())
+	var polled []*Backlog
+	m.OnPoll(func(res []*Backlog) {
+		polled = res
+		cancel()
+	})
+	if err := m.Run(ctx); err != context.Canceled {
+		t.Errorf("Run() = %v", err)
+	}
+	if len(polled) != 1 || polled[0].Name != "queue-a" {
+		t.Errorf("OnPoll got %+v", polled)
+	}
+}
+
+func TestMonitor_RunInvalidInterval(t *testing.T) {
+	m := NewAccountDefault("http://127.0.0.1:1", "id", "key").NewMonitor(0)
+	if err := m.Run(context.Background()); err == nil {
+		t.Error("Run() with zero interval should fail")
+	}
+}
@@ -0,0 +1,144 @@
+package cmq
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// 消息分组键，同一分组的消息在顺序消费模式下依次处理
+const HeaderGroupKey = "Group-Key"
+
+// 处理失败的消息超过下次可见时间这么久还没有重新收到时，认为它已经被其他消费者处理，不再阻塞分组
+const orderedHoldGrace = time.Minute
+
+// 缺省的分组键：消息头 Group-Key
+func GroupKey(m *Message) string {
+	return m.Header(HeaderGroupKey)
+}
+
+// 开启顺序消费：分组键相同的消息按收到的顺序依次处理，不同分组并行处理，总并发数不超过 SetConcurrency；
+// 每个分组最多缓存 bufferSize 条等待处理的消息，缓存满时暂停拉取。没有分组键的消息并行处理
+// 一条消息处理失败后，同一分组后面的消息不处理也不删除，在 visibilityTimeout 之后重新可见，
+// 直到失败的消息重新收到并处理成功（或被 RetryRouter 发送到死信目的地）
+func (c *Consumer) SetOrdered(bufferSize int) {
+	if bufferSize > 0 {
+		c.groupBuffer = bufferSize
+	}
+}
+
+// 设置顺序消费模式下计算分组键的函数，默认为 GroupKey
+func (c *Consumer) SetGroupKeyFunc(key func(m *Message) string) {
+	c.groupKey = key
+}
+
+// 顺序消费模式的 Run
+func (c *Consumer) runOrdered(ctx context.Context, handler Handler) error {
+	groupKey := c.groupKey
+	if groupKey == nil {
+		groupKey = GroupKey
+	}
+	sem := make(chan struct{}, c.concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	lanes := map[string]chan *Message{}
+	// 分组中处理失败、等待重新投递的消息；分发时持有 mu 阻塞发送，使用单独的锁
+	var heldMu sync.Mutex
+	held := map[string]*heldMessage{}
+
+	// 依次处理一个分组的消息，缓存为空时退出
+	drain := func(key string, lane chan *Message) {
+		defer wg.Done()
+		for {
+			select {
+			case m := <-lane:
+				// 停止后不再处理缓存的消息，它们在 visibilityTimeout 之后重新可见
+				if ctx.Err() != nil {
+					continue
+				}
+				heldMu.Lock()
+				h := held[key]
+				if h != nil && time.Now().After(h.until) {
+					delete(held, key)
+					h = nil
+				}
+				heldMu.Unlock()
+				if h != nil && h.msgId != m.MsgId {
+					// 前面的消息还没有处理成功，不处理也不删除
+					log.Println("hold message of group " + key + ", msgId: " + m.MsgId + ", waiting for " + h.msgId)
+					continue
+				}
+				sem <- struct{}{}
+				err := c.process(ctx, handler, m)
+				<-sem
+				heldMu.Lock()
+				if err != nil {
+					held[key] = holdMessage(m)
+				} else {
+					delete(held, key)
+				}
+				heldMu.Unlock()
+			default:
+				mu.Lock()
+				if len(lane) == 0 {
+					delete(lanes, key)
+					mu.Unlock()
+					return
+				}
+				mu.Unlock()
+			}
+		}
+	}
+
+	c.poll(ctx, func(m *Message) bool {
+		key := groupKey(m)
+		if len(key) == 0 {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return false
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.process(ctx, handler, m)
+				<-sem
+			}()
+			return true
+		}
+
+		// 持有锁发送，避免分组的协程在发送前退出
+		mu.Lock()
+		defer mu.Unlock()
+		lane, ok := lanes[key]
+		if !ok {
+			lane = make(chan *Message, c.groupBuffer)
+			lanes[key] = lane
+			wg.Add(1)
+			go drain(key, lane)
+		}
+		select {
+		case lane <- m:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	wg.Wait()
+	return ctx.Err()
+}
+
+type heldMessage struct {
+	msgId string
+	//超过这个时间还没有重新收到就不再等待
+	until time.Time
+}
+
+func holdMessage(m *Message) *heldMessage {
+	visible := time.Now()
+	if m.NextVisibleTime > 0 {
+		visible = time.Unix(0, m.NextVisibleTime*int64(time.Millisecond))
+	}
+	return &heldMessage{msgId: m.MsgId, until: visible.Add(orderedHoldGrace)}
+}
@@ -0,0 +1,254 @@
+package cmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// 转发 spool 中的消息失败后，等待多久再重试
+const spoolRetryInterval = time.Second
+
+// 生产者发送的消息
+type ProducerMessage struct {
+	//消息正文
+	Body string
+	//延时可见的秒数，只对队列有效
+	DelaySeconds int
+	//消息过滤标签，只对主题有效
+	Tags []string
+	//消息路由路径，只对主题有效
+	RoutingKey string
+	//消息头，和消息正文一起包装成信封发送
+	Headers map[string]string
+}
+
+// 生产者，向一个队列发送消息或向一个主题发布消息
+type Producer struct {
+	queue   *Queue
+	topic   *Topic
+	metrics Metrics
+	spool   *Spool
+	//压缩算法，为空时不压缩
+	compression          string
+	compressionThreshold int
+	//加密消息正文使用的密钥，为 nil 时不加密
+	keyProvider KeyProvider
+	//保存大消息正文的存储，为 nil 时不保存
+	blobStore           BlobStore
+	claimCheckThreshold int
+}
+
+// 创建向队列发送消息的生产者
+func NewQueueProducer(q *Queue) *Producer {
+	return &Producer{
+		queue:   q,
+		metrics: NopMetrics{},
+	}
+}
+
+// 创建向主题发布消息的生产者
+func NewTopicProducer(t *Topic) *Producer {
+	return &Producer{
+		topic:   t,
+		metrics: NopMetrics{},
+	}
+}
+
+// 设置监控指标
+func (p *Producer) SetMetrics(m Metrics) {
+	p.metrics = m
+}
+
+// 队列名或主题名
+func (p *Producer) Name() string {
+	if p.queue != nil {
+		return p.queue.queueName
+	}
+	return p.topic.topicName
+}
+
+// 设置压缩算法，消息正文达到 threshold 字节时压缩，threshold 小于等于 0 时使用 DefaultCompressionThreshold
+// 消费者收到消息时自动解压
+func (p *Producer) SetCompression(name string, threshold int) error {
+	if _, ok := compressorFor(name); !ok {
+		return fmt.Errorf("unknown compression %q", name)
+	}
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	p.compression = name
+	p.compressionThreshold = threshold
+	return nil
+}
+
+// 设置加密消息正文使用的密钥，消息正文先压缩再用 AES-GCM 加密；帐号的所有发送都需要加密时使用 CmqConfig.SetEncryption
+// 消费端需要通过 CmqConfig.SetKeyProvider 或 PushHandler.SetKeyProvider 设置能找到同一密钥的 KeyProvider
+func (p *Producer) SetEncryption(kp KeyProvider) {
+	p.keyProvider = kp
+}
+
+// 设置保存大消息正文的 BlobStore，最终的 msgBody 超过 threshold 字节时，消息正文保存到 store，只发送引用
+// threshold 小于等于 0 时使用 DefaultClaimCheckThreshold；消费端通过 CmqConfig.SetBlobStore 设置同一个存储后自动读取
+func (p *Producer) SetBlobStore(store BlobStore, threshold int) {
+	if threshold <= 0 {
+		threshold = DefaultClaimCheckThreshold
+	}
+	p.blobStore = store
+	p.claimCheckThreshold = threshold
+}
+
+// 设置本地 spool，CMQ 服务不可达时消息写入 spool，由 RunForwarder 转发
+func (p *Producer) SetSpool(s *Spool) {
+	s.mu.Lock()
+	s.resource = p.Name()
+	s.reportDepth()
+	s.mu.Unlock()
+	p.spool = s
+}
+
+// 发送一条消息，返回消息Id
+// 设置了 spool 时，服务不可达或 spool 中还有未转发的消息，消息写入 spool，返回空的消息Id
+// 写入 spool 的是压缩、加密之后的消息
+func (p *Producer) Send(ctx context.Context, m *ProducerMessage) (string, *CMQError) {
+	encoded, err := p.encode(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	if p.spool != nil && p.spool.Depth() > 0 {
+		// 保证消息顺序，spool 转发完之前新消息也写入 spool
+		err := p.spool.append(encoded)
+		if err == nil {
+			return "", nil
+		}
+		log.Println("spool message error, msg: " + err.Error())
+	}
+	msgId, err := p.send(ctx, encoded)
+	if err != nil && p.spool != nil && isEndpointError(err) {
+		serr := p.spool.append(encoded)
+		if serr == nil {
+			return "", nil
+		}
+		log.Println("spool message error, msg: " + serr.Error())
+	}
+	if err != nil && encoded.Headers[HeaderClaimCheck] != m.Headers[HeaderClaimCheck] {
+		// 没有发送出去，删除 encode 刚保存的 blob
+		p.deleteBlob(encoded)
+	}
+	return msgId, err
+}
+
+// 删除没有发送成功的消息正文在 BlobStore 中的 blob，发送的 ctx 可能已经结束，使用新的 context
+func (p *Producer) deleteBlob(m *ProducerMessage) {
+	ref := m.Headers[HeaderClaimCheck]
+	if len(ref) == 0 || p.blobStore == nil {
+		return
+	}
+	if err := p.blobStore.Delete(context.Background(), ref); err != nil {
+		log.Println("delete blob error, ref: " + ref + ", msg: " + err.Error())
+	}
+}
+
+// 发送已经按设置编码的消息
+func (p *Producer) send(ctx context.Context, m *ProducerMessage) (string, *CMQError) {
+	var msgId string
+	var err *CMQError
+	if p.queue != nil {
+		msgId, err = p.queue.WithContext(ctx).SendMessageWithHeaders(m.Body, m.Headers, m.DelaySeconds)
+	} else {
+		msgId, err = p.topic.WithContext(ctx).PublishMessageWithHeaders(m.Body, m.Headers, m.Tags, m.RoutingKey)
+	}
+	if err != nil {
+		return "", err
+	}
+	p.metrics.AddSent(p.Name(), 1)
+	return msgId, nil
+}
+
+// 按设置压缩、加密消息正文，太大的消息正文保存到 BlobStore，返回编码后的消息副本
+func (p *Producer) encode(ctx context.Context, m *ProducerMessage) (*ProducerMessage, *CMQError) {
+	body, headers := m.Body, m.Headers
+	var err error
+	if len(p.compression) != 0 && len(body) >= p.compressionThreshold {
+		if body, headers, err = CompressBody(body, headers, p.compression); err != nil {
+			return nil, NewCMQOpError(CMQError100, err, p.action())
+		}
+	}
+	if p.keyProvider != nil {
+		if body, headers, err = EncryptBody(body, headers, p.keyProvider); err != nil {
+			return nil, NewCMQOpError(CMQError100, err, p.action())
+		}
+	}
+	if p.blobStore != nil && len(WrapBody(body, headers)) > p.claimCheckThreshold {
+		if body, headers, err = checkBody(ctx, body, headers, p.blobStore); err != nil {
+			return nil, NewCMQOpError(CMQError100, err, p.action())
+		}
+	}
+	encoded := *m
+	encoded.Body, encoded.Headers = body, headers
+	return &encoded, nil
+}
+
+func (p *Producer) action() string {
+	if p.queue != nil {
+		return SendMessage
+	}
+	return PublishMessage
+}
+
+// 按写入顺序转发 spool 中的消息，直到 ctx 结束
+// 服务不可达时等待后重试；超过最长保留时间或服务端拒绝的消息丢弃
+func (p *Producer) RunForwarder(ctx context.Context) error {
+	if p.spool == nil {
+		return errors.New("producer has no spool")
+	}
+	s := p.spool
+	for {
+		rec, next, err := s.peek()
+		if err != nil {
+			log.Println("read spool error, msg: " + err.Error())
+			sleep(ctx, spoolRetryInterval)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		if rec == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.notify:
+			}
+			continue
+		}
+		if s.expired(rec) {
+			log.Println("drop expired spool message of " + p.Name())
+			s.dropped(1)
+			p.deleteBlob(rec.Message)
+			if err := s.commit(next); err != nil {
+				log.Println("commit spool error, msg: " + err.Error())
+			}
+			continue
+		}
+		if _, cerr := p.send(ctx, rec.Message); cerr != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if isEndpointError(cerr) {
+				sleep(ctx, spoolRetryInterval)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				continue
+			}
+			log.Println("drop spool message of " + p.Name() + ", msg: " + cerr.Error())
+			s.dropped(1)
+			p.deleteBlob(rec.Message)
+		}
+		if err := s.commit(next); err != nil {
+			log.Println("commit spool error, msg: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package cmq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 推送到 http 订阅的消息
+type Notification struct {
+	//主题所有者的appId
+	TopicOwner string
+	//主题名称
+	TopicName string
+	//订阅名称
+	SubscriptionName string
+	//消息Id，SIMPLIFIED 格式下为空
+	MsgId string
+	//消息正文
+	MsgBody string
+	//消息发布时间，SIMPLIFIED 格式下为零值
+	PublishTime time.Time
+	//消息过滤标签
+	MsgTag []string
+	//消息信封中的消息头，不是信封格式的消息为 nil
+	Headers map[string]string
+}
+
+// 处理推送消息，返回 nil 表示消费成功，返回错误时 CMQ 会按订阅的 notifyStrategy 重试
+type NotificationHandler func(ctx context.Context, n *Notification) error
+
+// JSON 格式的推送内容
+type pushMessage struct {
+	TopicOwner       jsonString `json:"topicOwner"`
+	TopicName        string     `json:"topicName"`
+	SubscriptionName string     `json:"subscriptionName"`
+	MsgId            string     `json:"msgId"`
+	MsgBody          string     `json:"msgBody"`
+	PublishTime      jsonString `json:"publishTime"`
+	MsgTag           []string   `json:"msgTag"`
+}
+
+// 接收 http 订阅推送的 http.Handler
+// 处理成功返回 200，CMQ 认为推送成功；
+// 请求格式错误返回 400，消息正文无法读取 blob、解密或解压返回 422，用户处理失败返回 500，CMQ 会按 notifyStrategy 重试。
+type PushHandler struct {
+	topicName        string
+	subscriptionName string
+	format           string
+	handler          NotificationHandler
+	keyProvider      KeyProvider
+	blobStore        BlobStore
+}
+
+// 创建推送消息的接收器
+// topicName、subscriptionName 用于 SIMPLIFIED 格式，这种格式的推送内容只有消息正文
+// notifyContentFormat 与订阅的 notifyContentFormat 相同，取值 JSON 或 SIMPLIFIED
+func NewPushHandler(topicName, subscriptionName, notifyContentFormat string, handler NotificationHandler) *PushHandler {
+	return &PushHandler{
+		topicName:        topicName,
+		subscriptionName: subscriptionName,
+		format:           notifyContentFormat,
+		handler:          handler,
+	}
+}
+
+// 设置解密推送消息使用的密钥
+func (h *PushHandler) SetKeyProvider(kp KeyProvider) {
+	h.keyProvider = kp
+}
+
+// 设置读取大消息正文的 BlobStore
+func (h *PushHandler) SetBlobStore(store BlobStore) {
+	h.blobStore = store
+}
+
+func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := h.parse(body)
+	if err != nil {
+		log.Println("parse push message error, msg: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 无法读取 blob、解密或解压的消息重试也不会成功，统一返回 422，CMQ 不再重试
+	msgBody, headers, err := decodeBody(r.Context(), n.MsgBody, n.Headers, h.keyProvider, h.blobStore)
+	if err != nil {
+		if de, ok := err.(*DecryptionError); ok {
+			de.MsgId = n.MsgId
+		}
+		log.Println("decode push message error, msg: " + err.Error())
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	n.MsgBody, n.Headers = msgBody, headers
+
+	if err := h.handler(r.Context(), n); err != nil {
+		log.Println(fmt.Sprintf("handle push message error, msgId: %s, msg: %v", n.MsgId, err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *PushHandler) parse(body []byte) (*Notification, error) {
+	if h.format == NotifyContentFormatSimplified {
+		if len(body) == 0 {
+			return nil, fmt.Errorf("empty push body")
+		}
+		n := &Notification{
+			TopicName:        h.topicName,
+			SubscriptionName: h.subscriptionName,
+		}
+		n.MsgBody, n.Headers, _ = UnwrapBody(string(body))
+		return n, nil
+	}
+	return parseNotification(body)
+}
+
+// 解析 JSON 格式的推送内容
+func ParseNotification(body []byte) (*Notification, error) {
+	n, err := parseNotification(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.decompress(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// 解析 JSON 格式的推送内容，不解码消息正文
+func parseNotification(body []byte) (*Notification, error) {
+	var pm pushMessage
+	if err := json.Unmarshal(body, &pm); err != nil {
+		return nil, err
+	}
+	if len(pm.MsgId) == 0 {
+		return nil, fmt.Errorf("msgId is empty")
+	}
+	n := &Notification{
+		TopicOwner:       string(pm.TopicOwner),
+		TopicName:        pm.TopicName,
+		SubscriptionName: pm.SubscriptionName,
+		MsgId:            pm.MsgId,
+		MsgTag:           pm.MsgTag,
+	}
+	n.MsgBody, n.Headers, _ = UnwrapBody(pm.MsgBody)
+	t, err := parsePublishTime(pm.PublishTime)
+	if err != nil {
+		return nil, err
+	}
+	n.PublishTime = t
+	return n, nil
+}
+
+// 解压消息正文；保存在 BlobStore 中或加密的消息正文由 PushHandler 处理
+func (n *Notification) decompress() error {
+	if !n.decoded() {
+		return nil
+	}
+	body, headers, err := DecompressBody(n.MsgBody, n.Headers)
+	if err != nil {
+		return err
+	}
+	n.MsgBody, n.Headers = body, headers
+	return nil
+}
+
+// 消息正文不需要从 BlobStore 读取或解密
+func (n *Notification) decoded() bool {
+	return len(n.Headers[HeaderClaimCheck]) == 0 && len(n.Headers[HeaderEncryption]) == 0
+}
+
+// 可能是数字也可能是字符串的字段，比如 appId
+type jsonString string
+
+func (s *jsonString) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return err
+	}
+	if v != nil {
+		*s = jsonString(fmt.Sprint(v))
+	}
+	return nil
+}
+
+// publishTime 可能是秒或毫秒，可能是数字或字符串
+func parsePublishTime(v jsonString) (time.Time, error) {
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+	ts, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid publishTime %q", string(v))
+	}
+	// 毫秒
+	if ts > 1e12 {
+		return time.Unix(0, ts*int64(time.Millisecond)), nil
+	}
+	return time.Unix(ts, 0), nil
+}
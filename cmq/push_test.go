@@ -0,0 +1,89 @@
+package cmq
+
+import (
+	"encoding/json"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPushHandler_JSON(t *testing.T) {
+	var got *Notification
+	h := NewPushHandler("", "", NotifyContentFormatDefault, func(ctx context.Context, n *Notification) error {
+		got = n
+		return nil
+	})
+
+	body := `{"TopicOwner":1253727555,"topicName":"topic1","subscriptionName":"sub1","msgId":"123","msgBody":"hello","publishTime":1483439519,"msgTag":["a","b"]}`
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if got.TopicOwner != "1253727555" || got.TopicName != "topic1" || got.SubscriptionName != "sub1" ||
+		got.MsgId != "123" || got.MsgBody != "hello" || got.PublishTime.Unix() != 1483439519 || len(got.MsgTag) != 2 {
+		t.Errorf("unexpected notification %+v", got)
+	}
+}
+
+func TestPushHandler_Simplified(t *testing.T) {
+	var got *Notification
+	h := NewPushHandler("topic1", "sub1", NotifyContentFormatSimplified, func(ctx context.Context, n *Notification) error {
+		got = n
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("raw body")))
+
+	if w.Code != http.StatusOK || got.MsgBody != "raw body" || got.TopicName != "topic1" {
+		t.Errorf("status = %d, notification %+v", w.Code, got)
+	}
+}
+
+func TestPushHandler_Errors(t *testing.T) {
+	h := NewPushHandler("", "", NotifyContentFormatDefault, func(ctx context.Context, n *Notification) error {
+		return errors.New("busy")
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"msgId":"1","msgBody":"x"}`)))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("handler error status = %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`not json`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad body status = %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d", w.Code)
+	}
+}
+
+func TestPushHandler_DecodeError(t *testing.T) {
+	h := NewPushHandler("", "", NotifyContentFormatDefault, func(ctx context.Context, n *Notification) error {
+		t.Error("undecodable message should not reach the handler")
+		return nil
+	})
+	for _, headers := range []map[string]string{
+		{HeaderContentEncoding: "gzip"},
+		{HeaderEncryption: EncryptionAESGCM},
+		{HeaderClaimCheck: "ref-1"},
+	} {
+		msgBody, _ := json.Marshal(WrapBody("not decodable", headers))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"msgId":"1","msgBody":`+string(msgBody)+`}`)))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%v: status = %d, want %d", headers, w.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package cmq
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"errors"
@@ -8,87 +9,93 @@ import (
 	"log"
 )
 
+type queueAttributes struct {
+	Code int					`json:"code"`
+	Message string				`json:"message"`
+	RequestId string			`json:"requestId"`
+	MaxMsgHeapNum int			`json:"maxMsgHeapNum"`
+	PollingWaitSeconds int		`json:"pollingWaitSeconds"`
+	VisibilityTimeout int		`json:"visibilityTimeout"`
+	MaxMsgSize int				`json:"maxMsgSize"`
+	MsgRetentionSeconds int		`json:"msgRetentionSeconds"`
+	CreateTime int				`json:"createTime"`
+	LastModifyTime int			`json:"lastModifyTime"`
+	ActiveMsgNum int			`json:"activeMsgNum"`
+	InactiveMsgNum int			`json:"inactiveMsgNum"`
+	RewindMsgNum int			`json:"rewindMsgNum"`
+	MinMsgTime int				`json:"minMsgTime"`
+	DelayMsgNum int				`json:"delayMsgNum"`
+	RewindSeconds int			`json:"rewindSeconds"`
+}
+
 type Queue struct {
 	client *Client
 	queueName string
 }
 
-// 设置队列属性
-func (q *Queue) SetQueueAttributes(meta *QueueMeta) *CMQError {
-
-	params := map[string]interface{} {
-		"queueName": q.queueName,
-	}
-
-	if meta.maxMsgHeapNum > 0 {
-		params["maxMsgHeapNum"] = meta.maxMsgHeapNum
-	}
-
-	if meta.pollingWaitSeconds > 0 {
-		params["pollingWaitSeconds"] = meta.pollingWaitSeconds
+//返回使用ctx发起调用的Queue
+//ctx用于取消请求，并传递给拦截器（比如链路追踪信息）
+func (q *Queue) WithContext(ctx context.Context) *Queue {
+	return &Queue{
+		client:q.client.withContext(ctx),
+		queueName:q.queueName,
 	}
+}
 
-	if meta.visibilityTimeout > 0 {
-		params["visibilityTimeout"] = meta.visibilityTimeout
-	}
+// 设置队列属性
+func (q *Queue) SetQueueAttributes(meta *QueueMeta) *CMQError {
 
-	if meta.maxMsgSize > 0 {
-		params["maxMsgSize"] = meta.maxMsgSize
+	if err := firstInvalid(validateName("queueName",q.queueName),validateQueueMeta(meta));err != nil {
+		return NewCMQOpError(CMQError100,err,SetQueueAttributes)
 	}
 
-	if meta.msgRetentionSeconds > 0 {
-		params["msgRetentionSeconds"] = meta.msgRetentionSeconds
-	}
-	if meta.rewindSeconds > 0 {
-		params["rewindSeconds"] = meta.rewindSeconds
-	}
-	return handleQueueApi(q,SetQueueAttributes,params)
+	return handleQueueApi(q,SetQueueAttributes,newQueueAttributesRequest(q.queueName,meta))
 }
 
 //获取队列属性
 func (q *Queue) GetQueueAttributes() (*QueueMeta,*CMQError) {
-	params := map[string]interface{} {
-		"queueName":q.queueName,
+	if err := validateName("queueName",q.queueName);err != nil {
+		return nil,NewCMQOpError(CMQError100,err,GetQueueAttributes)
 	}
 
-	result, err := q.client.cmqCall(GetQueueAttributes, params)
+	result, err := q.client.call(GetQueueAttributes, &queueRequest{QueueName:q.queueName})
 	if err != nil {
 		log.Println("create queue error msg: " + err.Error())
 		return nil,err
 	}
 
-	var res map[string]interface{}
+	var res queueAttributes
 	if err := json.Unmarshal([]byte(result),&res);err != nil {
 		log.Println("parse json string error, msg: " + err.Error())
 		return nil,NewCMQOpError(CMQError102,jsonUnmarshal,GetQueueAttributes)
 	}
-	code := res["code"].(int)
+	code := res.Code
 	if code != 0 {
-		log.Println(fmt.Sprintf("code:%d, %v, RequestId: %v",code,res["message"],res["requestId"]))
-		return nil,NewCMQOpError(erron(code),errors.New(res["message"].(string)),GetQueueAttributes)
+		log.Println(fmt.Sprintf("code:%d, %v, RequestId: %v",code,res.Message,res.RequestId))
+		return nil,NewCMQOpError(erron(code),errors.New(res.Message),GetQueueAttributes)
 	}
 
 	meta := &QueueMeta{
-		maxMsgHeapNum:			res["maxMsgHeapNum"].(int),
-		pollingWaitSeconds:		res["pollingWaitSeconds"].(int),
-		visibilityTimeout:		res["visibilityTimeout"].(int),
-		maxMsgSize:				res["maxMsgSize"].(int),
-		msgRetentionSeconds:	res["msgRetentionSeconds"].(int),
-		createTime:				res["createTime"].(int),
-		lastModifyTime:			res["lastModifyTime"].(int),
-		activeMsgNum:			res["activeMsgNum"].(int),
-		inactiveMsgNum:			res["inactiveMsgNum"].(int),
-		rewindmsgNum:			res["rewindMsgNum"].(int),
-		minMsgTime:				res["minMsgTime"].(int),
-		delayMsgNum:			res["delayMsgNum"].(int),
-		rewindSeconds:			res["rewindSeconds"].(int),
+		maxMsgHeapNum:			res.MaxMsgHeapNum,
+		pollingWaitSeconds:		res.PollingWaitSeconds,
+		visibilityTimeout:		res.VisibilityTimeout,
+		maxMsgSize:				res.MaxMsgSize,
+		msgRetentionSeconds:	res.MsgRetentionSeconds,
+		createTime:				res.CreateTime,
+		lastModifyTime:			res.LastModifyTime,
+		activeMsgNum:			res.ActiveMsgNum,
+		inactiveMsgNum:			res.InactiveMsgNum,
+		rewindmsgNum:			res.RewindMsgNum,
+		minMsgTime:				res.MinMsgTime,
+		delayMsgNum:			res.DelayMsgNum,
+		rewindSeconds:			res.RewindSeconds,
 	}
 
 	return meta,nil;
 }
 
-func handleQueueApi(q *Queue,action string,params map[string]interface{}) *CMQError {
-	result, err := q.client.cmqCall(action, params)
+func handleQueueApi(q *Queue,action string,req interface{}) *CMQError {
+	result, err := q.client.call(action, req)
 	if err != nil {
 		log.Println("create queue error msg: " + err.Error())
 		return err
@@ -111,22 +118,22 @@ func handleQueueApi(q *Queue,action string,params map[string]interface{}) *CMQEr
 // msgBody 消息正文。至少 1 Byte，最大长度受限于设置的队列消息最大长度属性。
 // delaySeconds 单位为秒，表示该消息发送到队列后，需要延时多久用户才可见该消息。传0表示立即可见
 func (q *Queue) SendMessage(msgBody string,delaySeconds int) (result string,err *CMQError) {
-
-	if len(msgBody) == 0 {
-		return "",NewCMQOpError(CMQError100,errors.New("msgBoy is empty!"),SendMessage)
+	msgBody,eerr := q.client.account.encryptMsgBody(msgBody)
+	if eerr != nil {
+		return "",NewCMQOpError(CMQError100,eerr,SendMessage)
 	}
-
-	if delaySeconds < 0 {
-		return "",NewCMQOpError(CMQError100,errors.New("delaySeconds is < 0!"),SendMessage)
+	if err := firstInvalid(
+		validateName("queueName",q.queueName),
+		validateMsgBody("msgBody",msgBody),
+		validateRange("delaySeconds",delaySeconds,0,MaxDelaySeconds));err != nil {
+		return "",NewCMQOpError(CMQError100,err,SendMessage)
 	}
 
-	params := map[string]interface{} {
-		"queueName":q.queueName,
-		"msgBody":msgBody,
-		"delaySeconds":delaySeconds,
-	}
-
-	r,err := q.client.cmqCall(SendMessage, params)
+	r,err := q.client.call(SendMessage, &sendMessageRequest{
+		QueueName:q.queueName,
+		MsgBody:msgBody,
+		DelaySeconds:delaySeconds,
+	})
 
 	if err != nil {
 		return "",err
@@ -137,34 +144,41 @@ func (q *Queue) SendMessage(msgBody string,delaySeconds int) (result string,err
 	}
 	code := message.Code
 	if code != 0 {
-		log.Println("parse json string error, msg: " + err.Error())
+		log.Println(fmt.Sprintf("code:%d, %v, RequestId: %v",code,message.Message,message.RequestId))
 		return "",NewCMQOpError(erron(code),errors.New(message.Message),SendMessage)
 	}
 
 	return message.MsgId,nil
 }
 
+// 发送带消息头的消息，消息头和消息正文包装成信封（Envelope）放在 msgBody 中，headers 为空时和 SendMessage 相同
+// 接收时 ReceiveMessage、BatchReceiveMessage 自动解开信封，消息头放在 Message.Headers 中
+func (q *Queue) SendMessageWithHeaders(msgBody string,headers map[string]string,delaySeconds int) (string,*CMQError) {
+	return q.SendMessage(WrapBody(msgBody,headers),delaySeconds)
+}
+
 // 批量发送
 // msgBodys 消息正文。表示这一批量中的一条消息。目前批量消息数量不能超过 16 条。
 // 为方便用户使用，n从0开始或者从1开始都可以，但必须连续，例如发送两条消息，可以是(msgBody.0, msgBody.1)，或者(msgBody.1, msgBody.2)。
 // 注意：由于目前限制所有消息大小总和（不包含消息头和其他参数，仅msgBody）不超过 64k，所以建议提前规划好批量发送的数量。
 // delaySeconds 单位为秒，表示该消息发送到队列后，需要延时多久用户才可见。（该延时对一批消息有效，不支持多对多映射）
 func (q *Queue) BatchSendMessage(msgBodys []string,delaySeconds int) (result []string,err *CMQError)  {
-
-	if msgBodys == nil || len(msgBodys) == 0 || len(msgBodys) > 16 {
-		return nil,NewCMQOpError(CMQError100,errors.New("Error: message size is empty or more than 16"),BatchSendMessage)
-	}
-
-	params := map[string]interface{} {
-		"queueName":q.queueName,
-		"delaySeconds":delaySeconds,
+	msgBodys,eerr := q.client.account.encryptMsgBodies(msgBodys)
+	if eerr != nil {
+		return nil,NewCMQOpError(CMQError100,eerr,BatchSendMessage)
 	}
-
-	for i,v := range msgBodys {
-		params["msgBody." + strconv.Itoa(i)] = v
+	if err := firstInvalid(
+		validateName("queueName",q.queueName),
+		validateBatchBodies("msgBody",msgBodys),
+		validateRange("delaySeconds",delaySeconds,0,MaxDelaySeconds));err != nil {
+		return nil,NewCMQOpError(CMQError100,err,BatchSendMessage)
 	}
 
-	r, err := q.client.cmqCall(BatchSendMessage, params)
+	r, err := q.client.call(BatchSendMessage, &batchSendMessageRequest{
+		QueueName:q.queueName,
+		DelaySeconds:delaySeconds,
+		MsgBody:msgBodys,
+	})
 
 	if err != nil {
 		return nil,err
@@ -195,17 +209,12 @@ func (q *Queue) BatchSendMessage(msgBodys []string,delaySeconds int) (result []s
 //接受消息
 // pollingWaitSeconds 本次请求的长轮询等待时间。取值范围 0-30 秒，如果不设置该参数，则默认使用队列属性中的 pollingWaitSeconds 值。
 func (q *Queue) ReceiveMessage(pollingWaitSeconds int) (msg *Message,err *CMQError) {
-	params := map[string]interface{} {
-		"queueName":q.queueName,
+	if verr := firstInvalid(
+		validateName("queueName",q.queueName),
+		validatePollingWaitSeconds(pollingWaitSeconds));verr != nil {
+		return nil,NewCMQOpError(CMQError100,verr,ReceiveMessage)
 	}
-	if pollingWaitSeconds >=  0 {
-		params["UserpollingWaitSeconds"] = pollingWaitSeconds + 3
-		params["pollingWaitSeconds"] = pollingWaitSeconds
-	} else {
-		params["UserpollingWaitSeconds"] = 30
-	}
-
-	result, err := q.client.cmqCall(ReceiveMessage, params)
+	result, err := q.client.call(ReceiveMessage, newReceiveMessageRequest(q.queueName,0,pollingWaitSeconds))
 	if err != nil {
 		return nil,err
 	}
@@ -219,6 +228,7 @@ func (q *Queue) ReceiveMessage(pollingWaitSeconds int) (msg *Message,err *CMQErr
 	if message.Code != 0 {
 		return nil,NewCMQOpError(erron(message.Code),errors.New(message.Message),ReceiveMessage)
 	}
+	message.unwrap(q.client.context(),q.client.account.keyProvider,q.client.account.blobStore)
 
 	return &message,nil;
 }
@@ -228,17 +238,14 @@ func (q *Queue) ReceiveMessage(pollingWaitSeconds int) (msg *Message,err *CMQErr
 // pollingWaitSeconds     请求最长的Polling等待时间
 func (q *Queue) BatchReceiveMessage(numOfMsg,pollingWaitSeconds int) (result []Message,err *CMQError) {
 
-	params := map[string]interface{} {
-		"queueName":q.queueName,
-		"numOfMsg":numOfMsg,
+	if verr := firstInvalid(
+		validateName("queueName",q.queueName),
+		validateBatchSize("numOfMsg",numOfMsg),
+		validatePollingWaitSeconds(pollingWaitSeconds));verr != nil {
+		return nil,NewCMQOpError(CMQError100,verr,BatchReceiveMessage)
 	}
-	if pollingWaitSeconds >= 0 {
-		params["UserpollingWaitSeconds"] = pollingWaitSeconds + 3
-		params["pollingWaitSeconds"] = pollingWaitSeconds
-	} else {
-		params["UserpollingWaitSeconds"] = 30
-	}
-	r, err := q.client.cmqCall(BatchReceiveMessage, params)
+
+	r, err := q.client.call(BatchReceiveMessage, newReceiveMessageRequest(q.queueName,numOfMsg,pollingWaitSeconds))
 
 	if err != nil {
 		return nil,err
@@ -270,6 +277,7 @@ func (q *Queue) BatchReceiveMessage(numOfMsg,pollingWaitSeconds int) (result []M
 			FirstDequeueTime:v.FirstDequeueTime,
 			DequeueCount:v.DequeueCount,
 		}
+		msgs[i].unwrap(q.client.context(),q.client.account.keyProvider,q.client.account.blobStore)
 	}
 
 	return msgs,nil
@@ -279,12 +287,14 @@ func (q *Queue) BatchReceiveMessage(numOfMsg,pollingWaitSeconds int) (result []M
 // receiptHandle 上次消费返回唯一的消息句柄，用于删除消息。
 func (q *Queue) DeleteMessage(receiptHandle string) *CMQError {
 
-	params := map[string]interface{} {
-		"queueName":q.queueName,
-		"receiptHandle":receiptHandle,
+	if err := firstInvalid(validateName("queueName",q.queueName),validateNotEmpty("receiptHandle",receiptHandle));err != nil {
+		return NewCMQOpError(CMQError100,err,DeleteMessage)
 	}
 
-	result, err := q.client.cmqCall(DeleteMessage, params)
+	result, err := q.client.call(DeleteMessage, &deleteMessageRequest{
+		QueueName:q.queueName,
+		ReceiptHandle:receiptHandle,
+	})
 
 	if err != nil {
 		return err
@@ -306,19 +316,19 @@ func (q *Queue) DeleteMessage(receiptHandle string) *CMQError {
 // receiptHandle 上次消费返回唯一的消息句柄，用于删除消息。
 func (q *Queue) BatchDeleteMessage(receiptHandles []string) *CMQError {
 
-	if receiptHandles == nil || len(receiptHandles) == 0 {
-		return NewCMQOpError(CMQError100,errors.New("receiptHandles is nil or empty!"),BatchDeleteMessage)
+	if err := firstInvalid(validateName("queueName",q.queueName),validateBatchSize("receiptHandle",len(receiptHandles)));err != nil {
+		return NewCMQOpError(CMQError100,err,BatchDeleteMessage)
 	}
-
-	params := map[string]interface{} {
-		"queueName":q.queueName,
-	}
-
 	for i,rh := range receiptHandles {
-		params["receiptHandle." + strconv.Itoa(i)] = rh
+		if err := validateNotEmpty("receiptHandle." + strconv.Itoa(i),rh);err != nil {
+			return NewCMQOpError(CMQError100,err,BatchDeleteMessage)
+		}
 	}
 
-	result, err := q.client.cmqCall(BatchDeleteMessage, params)
+	result, err := q.client.call(BatchDeleteMessage, &batchDeleteMessageRequest{
+		QueueName:q.queueName,
+		ReceiptHandle:receiptHandles,
+	})
 
 	if err != nil {
 		return err
@@ -0,0 +1,120 @@
+package cmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// 客户端令牌桶限流，可以同时配置全局、按 Action 和按队列名/主题名的限制，一次调用消耗每个匹配的桶各一个令牌
+//
+//	limiter := cmq.NewRateLimiter()
+//	limiter.SetResource("order", 500, 50)
+//	account.AddInterceptor(limiter.Interceptor())
+type RateLimiter struct {
+	mu        sync.RWMutex
+	global    *rate.Limiter
+	actions   map[string]*rate.Limiter
+	resources map[string]*rate.Limiter
+	failFast  bool
+}
+
+// 创建限流器，没有配置任何限制时不限流
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		actions:   map[string]*rate.Limiter{},
+		resources: map[string]*rate.Limiter{},
+	}
+}
+
+// 设置所有调用的总 QPS，burst 为允许的突发请求数
+func (l *RateLimiter) SetGlobal(qps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.global = rate.NewLimiter(rate.Limit(qps), burst)
+}
+
+// 设置某个 Action 的 QPS，比如 SendMessage
+func (l *RateLimiter) SetAction(action string, qps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.actions[action] = rate.NewLimiter(rate.Limit(qps), burst)
+}
+
+// 设置某个队列或主题的 QPS
+func (l *RateLimiter) SetResource(resource string, qps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.resources[resource] = rate.NewLimiter(rate.Limit(qps), burst)
+}
+
+// 设置为 true 时没有令牌立即返回 CMQError1014，否则等待令牌直到 ctx 结束
+func (l *RateLimiter) SetFailFast(failFast bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failFast = failFast
+}
+
+// 返回执行限流的拦截器
+func (l *RateLimiter) Interceptor() Interceptor {
+	return func(ctx context.Context, action string, params map[string]interface{}, invoker Invoker) (string, *CMQError) {
+		if err := l.Wait(ctx, action, resourceName(params)); err != nil {
+			return "", NewCMQOpError(CMQError1014, err, action)
+		}
+		return invoker(ctx, action, params)
+	}
+}
+
+// 等待 action 和 resource 对应的所有令牌桶都有令牌
+// 令牌不足且设置了 failFast，或者 ctx 在拿到令牌之前结束时返回错误，已经预留的令牌会归还
+func (l *RateLimiter) Wait(ctx context.Context, action, resource string) error {
+	l.mu.RLock()
+	limiters := []*rate.Limiter{l.global, l.actions[action], l.resources[resource]}
+	failFast := l.failFast
+	l.mu.RUnlock()
+
+	now := time.Now()
+	var reservations []*rate.Reservation
+	cancel := func() {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+	var delay time.Duration
+	for _, lim := range limiters {
+		if lim == nil {
+			continue
+		}
+		r := lim.ReserveN(now, 1)
+		if !r.OK() {
+			cancel()
+			return errRateLimited
+		}
+		reservations = append(reservations, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	if delay == 0 {
+		return nil
+	}
+	if failFast {
+		cancel()
+		return errRateLimited
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
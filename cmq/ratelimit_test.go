@@ -0,0 +1,61 @@
+package cmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestRateLimiter_FailFast(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+
+	limiter := NewRateLimiter()
+	limiter.SetResource("queue-a", 1, 2)
+	limiter.SetFailFast(true)
+	account := NewAccountDefault(s.URL, "id", "key")
+	account.AddInterceptor(limiter.Interceptor())
+
+	for i := 0; i < 2; i++ {
+		if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err == nil || err.Code != CMQError1014 {
+		t.Fatalf("want rate limited error, got %v", err)
+	}
+	if _, err := account.GetQueue("queue-b").SendMessage("hello", 0); err != nil {
+		t.Errorf("other queues should not be limited, got %v", err)
+	}
+	if n := len(s.Calls()); n != 3 {
+		t.Errorf("server calls = %d", n)
+	}
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := NewRateLimiter()
+	limiter.SetGlobal(1000, 10)
+	limiter.SetAction(SendMessage, 20, 1)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background(), SendMessage, "queue-a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("3 calls at 20 qps with burst 1 took %v", d)
+	}
+	if err := limiter.Wait(context.Background(), ReceiveMessage, "queue-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	limiter.Wait(context.Background(), SendMessage, "queue-a")
+	if err := limiter.Wait(ctx, SendMessage, "queue-a"); err != context.DeadlineExceeded {
+		t.Errorf("want deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package cmq
+
+import "github.com/zyw/cmq-goclient/util"
+
+// 各个 Action 的请求参数，由 util.EncodeParams 按 cmq tag 转换为请求参数
+
+type queueRequest struct {
+	QueueName string `cmq:"queueName"`
+}
+
+type queueAttributesRequest struct {
+	QueueName           string `cmq:"queueName"`
+	MaxMsgHeapNum       int    `cmq:"maxMsgHeapNum,omitempty"`
+	PollingWaitSeconds  int    `cmq:"pollingWaitSeconds,omitempty"`
+	VisibilityTimeout   int    `cmq:"visibilityTimeout,omitempty"`
+	MaxMsgSize          int    `cmq:"maxMsgSize,omitempty"`
+	MsgRetentionSeconds int    `cmq:"msgRetentionSeconds,omitempty"`
+	RewindSeconds       int    `cmq:"rewindSeconds,omitempty"`
+}
+
+func newQueueAttributesRequest(queueName string, meta *QueueMeta) *queueAttributesRequest {
+	return &queueAttributesRequest{
+		QueueName:           queueName,
+		MaxMsgHeapNum:       meta.maxMsgHeapNum,
+		PollingWaitSeconds:  meta.pollingWaitSeconds,
+		VisibilityTimeout:   meta.visibilityTimeout,
+		MaxMsgSize:          meta.maxMsgSize,
+		MsgRetentionSeconds: meta.msgRetentionSeconds,
+		RewindSeconds:       meta.rewindSeconds,
+	}
+}
+
+type sendMessageRequest struct {
+	QueueName    string `cmq:"queueName"`
+	MsgBody      string `cmq:"msgBody"`
+	DelaySeconds int    `cmq:"delaySeconds"`
+}
+
+type batchSendMessageRequest struct {
+	QueueName    string   `cmq:"queueName"`
+	DelaySeconds int      `cmq:"delaySeconds"`
+	MsgBody      []string `cmq:"msgBody,start=0"`
+}
+
+type receiveMessageRequest struct {
+	QueueName string `cmq:"queueName"`
+	NumOfMsg  int    `cmq:"numOfMsg,omitempty"`
+	//小于 0 时不传，使用队列属性中的 pollingWaitSeconds
+	PollingWaitSeconds *int `cmq:"pollingWaitSeconds"`
+	//客户端 http 请求的超时时间，单位秒
+	UserPollingWaitSeconds int `cmq:"UserpollingWaitSeconds"`
+}
+
+func newReceiveMessageRequest(queueName string, numOfMsg, pollingWaitSeconds int) *receiveMessageRequest {
+	req := &receiveMessageRequest{
+		QueueName:              queueName,
+		NumOfMsg:               numOfMsg,
+		UserPollingWaitSeconds: 30,
+	}
+	if pollingWaitSeconds >= 0 {
+		req.PollingWaitSeconds = &pollingWaitSeconds
+		req.UserPollingWaitSeconds = pollingWaitSeconds + 3
+	}
+	return req
+}
+
+type deleteMessageRequest struct {
+	QueueName     string `cmq:"queueName"`
+	ReceiptHandle string `cmq:"receiptHandle"`
+}
+
+type batchDeleteMessageRequest struct {
+	QueueName     string   `cmq:"queueName"`
+	ReceiptHandle []string `cmq:"receiptHandle,start=0"`
+}
+
+type listRequest struct {
+	TopicName  string `cmq:"topicName,omitempty"`
+	SearchWord string `cmq:"searchWord,omitempty"`
+	//小于 0 时不传
+	Offset *int `cmq:"offset"`
+	//小于等于 0 时不传
+	Limit *int `cmq:"limit"`
+}
+
+func newListRequest(searchWord string, offset, limit int) *listRequest {
+	req := &listRequest{SearchWord: searchWord}
+	if offset >= 0 {
+		req.Offset = &offset
+	}
+	if limit > 0 {
+		req.Limit = &limit
+	}
+	return req
+}
+
+type topicRequest struct {
+	TopicName string `cmq:"topicName"`
+}
+
+type createTopicRequest struct {
+	TopicName  string `cmq:"topicName"`
+	MaxMsgSize int    `cmq:"maxMsgSize"`
+	FilterType int    `cmq:"filterType"`
+}
+
+type topicAttributesRequest struct {
+	TopicName  string `cmq:"topicName"`
+	MaxMsgSize int    `cmq:"maxMsgSize"`
+}
+
+type publishMessageRequest struct {
+	TopicName  string   `cmq:"topicName"`
+	MsgBody    string   `cmq:"msgBody"`
+	RoutingKey string   `cmq:"routingKey,omitempty"`
+	MsgTag     []string `cmq:"msgTag"`
+}
+
+type batchPublishMessageRequest struct {
+	TopicName  string   `cmq:"topicName"`
+	RoutingKey string   `cmq:"routingKey,omitempty"`
+	MsgBody    []string `cmq:"msgBody"`
+	MsgTag     []string `cmq:"msgTag"`
+}
+
+type subscriptionRequest struct {
+	TopicName        string `cmq:"topicName"`
+	SubscriptionName string `cmq:"subscriptionName"`
+}
+
+type subscribeRequest struct {
+	TopicName           string   `cmq:"topicName"`
+	SubscriptionName    string   `cmq:"subscriptionName"`
+	Endpoint            string   `cmq:"endpoint"`
+	Protocol            string   `cmq:"protocol"`
+	NotifyStrategy      string   `cmq:"notifyStrategy"`
+	NotifyContentFormat string   `cmq:"notifyContentFormat"`
+	FilterTag           []string `cmq:"filterTag"`
+	BindingKey          []string `cmq:"bindingKey"`
+}
+
+type subscriptionAttributesRequest struct {
+	TopicName           string   `cmq:"topicName"`
+	SubscriptionName    string   `cmq:"subscriptionName"`
+	NotifyStrategy      string   `cmq:"notifyStrategy,omitempty"`
+	NotifyContentFormat string   `cmq:"notifyContentFormat,omitempty"`
+	FilterTag           []string `cmq:"filterTag"`
+	BindingKey          []string `cmq:"bindingKey"`
+}
+
+// 把请求结构体转换为请求参数后调用 CMQ API
+func (cc *Client) call(action string, req interface{}) (string, *CMQError) {
+	params, err := util.EncodeParams(req)
+	if err != nil {
+		return "", NewCMQOpError(CMQError100, err, action)
+	}
+	return cc.cmqCall(action, params)
+}
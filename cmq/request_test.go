@@ -0,0 +1,45 @@
+package cmq
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zyw/cmq-goclient/util"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// 按请求结构体编码后的参数，和 testdata 中的 golden 文件比较
+func TestRequest_EncodeParams(t *testing.T) {
+	cases := map[string]interface{}{
+		"send":            &sendMessageRequest{QueueName: "queue-a", MsgBody: "hello world&=", DelaySeconds: 0},
+		"batch_send":      &batchSendMessageRequest{QueueName: "queue-a", DelaySeconds: 5, MsgBody: []string{"a", "b", "c"}},
+		"publish":         &publishMessageRequest{TopicName: "topic-a", MsgBody: "{\"id\":1}", MsgTag: []string{"x", "y"}, RoutingKey: "order.created"},
+		"publish_omitted": &publishMessageRequest{TopicName: "topic-a", MsgBody: "hello"},
+		"batch_publish":   &batchPublishMessageRequest{TopicName: "topic-a", MsgBody: []string{"a", "b"}, MsgTag: []string{"x"}},
+	}
+	for name, req := range cases {
+		params, err := util.EncodeParams(req)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := util.MapToURLParam(params, false) + "\n" + util.MapToURLParam(params, true) + "\n"
+
+		golden := filepath.Join("testdata", name+".golden")
+		if *update {
+			if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", name, got, want)
+		}
+	}
+}
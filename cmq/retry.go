@@ -0,0 +1,148 @@
+package cmq
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	//消息已经重试的次数，第一次投递时没有这个消息头
+	HeaderRetryAttempt = "Retry-Attempt"
+	//进入死信目的地的原因，为最后一次处理失败的错误信息
+	HeaderDeadLetterReason = "Dead-Letter-Reason"
+)
+
+// 缺省的重试间隔，依次为第 1、2、3 次重试前的延时
+var DefaultRetryDelays = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+
+// 按阶段延时重试处理失败的消息
+// Handler 返回错误时，把消息带上递增的 Retry-Attempt 重新发送，延时为当前阶段的重试间隔，
+// 然后让消费者删除原消息；重试次数用完或消息无法解码（DecodeErr）时发送到死信目的地。
+// 重新发送失败时返回原来的错误，消息在 visibilityTimeout 之后重新可见
+//
+//	router := cmq.NewRetryRouter(cmq.NewQueueProducer(queue))
+//	router.SetDeadLetter(cmq.NewQueueProducer(deadLetterQueue))
+//	consumer.Use(router.Middleware())
+type RetryRouter struct {
+	retry      *Producer
+	stages     []*Producer
+	delays     []time.Duration
+	deadLetter *Producer
+}
+
+// 创建重试路由，retry 为重新发送消息的生产者，一般发送到消费的队列本身；
+// 消息加密、压缩时 retry 需要使用相同的配置，否则重试的消息以明文发送
+func NewRetryRouter(retry *Producer) *RetryRouter {
+	return &RetryRouter{
+		retry:  retry,
+		delays: DefaultRetryDelays,
+	}
+}
+
+// 设置每个阶段的重试间隔，阶段数即最大重试次数；单个间隔最多 MaxDelaySeconds 秒
+func (r *RetryRouter) SetDelays(delays ...time.Duration) {
+	r.delays = delays
+}
+
+// 每个阶段使用单独的重试队列，第 i 次重试发送到 producers[i-1]，阶段多于 producers 时使用最后一个
+// 重试队列也需要使用同一个 RetryRouter 消费
+func (r *RetryRouter) SetStageProducers(producers ...*Producer) {
+	r.stages = producers
+}
+
+// 设置死信目的地，不设置时重试次数用完后返回错误，由 CMQ 按 visibilityTimeout 重新投递
+func (r *RetryRouter) SetDeadLetter(p *Producer) {
+	r.deadLetter = p
+}
+
+// Consumer 的 Handler 中间件
+func (r *RetryRouter) Middleware() HandlerMiddleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, m *Message) error {
+			err := next(ctx, m)
+			if err == nil {
+				return nil
+			}
+			// 无法解密、解压或读取 blob 的消息重试也不会成功，直接发送到死信目的地；
+			// Retry-Attempt 不合法（比如负数）的消息也不再重试
+			attempt, aerr := retryAttempt(m)
+			if m.DecodeErr == nil && aerr == nil && attempt < len(r.delays) {
+				if rerr := r.resend(ctx, m, attempt+1); rerr != nil {
+					log.Println("retry message error, msgId: " + m.MsgId + ", msg: " + rerr.Error())
+					return err
+				}
+				return nil
+			}
+			if r.deadLetter == nil {
+				return err
+			}
+			if derr := r.sendDeadLetter(ctx, m, err); derr != nil {
+				log.Println("dead letter message error, msgId: " + m.MsgId + ", msg: " + derr.Error())
+				return err
+			}
+			return nil
+		}
+	}
+}
+
+// 消息已经重试的次数，没有 Retry-Attempt 时为 0
+func retryAttempt(m *Message) (int, error) {
+	v := m.Header(HeaderRetryAttempt)
+	if len(v) == 0 {
+		return 0, nil
+	}
+	attempt, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if attempt < 0 {
+		return 0, invalid(HeaderRetryAttempt, v, "is negative")
+	}
+	return attempt, nil
+}
+
+// 重试次数 attempt 从 1 开始
+func (r *RetryRouter) resend(ctx context.Context, m *Message, attempt int) *CMQError {
+	p := r.retry
+	if len(r.stages) != 0 {
+		i := attempt - 1
+		if i >= len(r.stages) {
+			i = len(r.stages) - 1
+		}
+		p = r.stages[i]
+	}
+	seconds := int(r.delays[attempt-1] / time.Second)
+	if seconds > MaxDelaySeconds {
+		seconds = MaxDelaySeconds
+	}
+	_, err := p.Send(ctx, &ProducerMessage{
+		Body:         m.MsgBody,
+		DelaySeconds: seconds,
+		Headers:      retryHeaders(m, HeaderRetryAttempt, strconv.Itoa(attempt)),
+	})
+	return err
+}
+
+func (r *RetryRouter) sendDeadLetter(ctx context.Context, m *Message, cause error) *CMQError {
+	if m.DecodeErr != nil {
+		// 消息正文和消息头还是编码后的样子，不再编码，保留 Claim-Check
+		_, err := r.deadLetter.send(ctx, &ProducerMessage{
+			Body:    m.MsgBody,
+			Headers: withHeader(m.Headers, HeaderDeadLetterReason, cause.Error()),
+		})
+		return err
+	}
+	_, err := r.deadLetter.Send(ctx, &ProducerMessage{
+		Body:    m.MsgBody,
+		Headers: retryHeaders(m, HeaderDeadLetterReason, cause.Error()),
+	})
+	return err
+}
+
+// 重新发送时使用的消息头，Body 已经从 BlobStore 读取，去掉原来的 Claim-Check
+func retryHeaders(m *Message, key, value string) map[string]string {
+	headers := withHeader(m.Headers, HeaderClaimCheck, "")
+	return withHeader(headers, key, value)
+}
@@ -0,0 +1,149 @@
+package cmq
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestRetryRouter_Middleware(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	router := NewRetryRouter(NewQueueProducer(account.GetQueue("queue-a")))
+	router.SetDelays(time.Minute, 10*time.Minute)
+	router.SetDeadLetter(NewQueueProducer(account.GetQueue("queue-dlq")))
+	handler := router.Middleware()(func(ctx context.Context, m *Message) error {
+		return errors.New("boom")
+	})
+
+	ctx := context.Background()
+	m := &Message{MsgId: "1", MsgBody: "hello", Headers: map[string]string{HeaderCorrelationId: "c-1"}}
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := handler(ctx, m); err != nil {
+			t.Fatalf("attempt %d: %v", attempt, err)
+		}
+		sent := s.Messages("queue-a")
+		if len(sent) != attempt {
+			t.Fatalf("attempt %d: resent %d messages", attempt, len(sent))
+		}
+		body, headers, _ := UnwrapBody(sent[attempt-1].Body)
+		if body != "hello" || headers[HeaderCorrelationId] != "c-1" {
+			t.Errorf("attempt %d: resent %q %v", attempt, body, headers)
+		}
+		if headers[HeaderRetryAttempt] != strconv.Itoa(attempt) {
+			t.Errorf("attempt %d: Retry-Attempt = %q", attempt, headers[HeaderRetryAttempt])
+		}
+		m = &Message{MsgId: sent[attempt-1].MsgId, MsgBody: body, Headers: headers}
+	}
+
+	if err := handler(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	dead := s.Messages("queue-dlq")
+	if len(dead) != 1 {
+		t.Fatalf("dead letters = %d, want 1", len(dead))
+	}
+	if _, headers, _ := UnwrapBody(dead[0].Body); headers[HeaderDeadLetterReason] != "boom" {
+		t.Errorf("Dead-Letter-Reason = %q", headers[HeaderDeadLetterReason])
+	}
+}
+
+func TestRetryRouter_NoDeadLetter(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	router := NewRetryRouter(NewQueueProducer(account.GetQueue("queue-a")))
+	router.SetDelays()
+	handler := router.Middleware()(func(ctx context.Context, m *Message) error {
+		return errors.New("boom")
+	})
+	if err := handler(context.Background(), &Message{MsgId: "1", MsgBody: "hello"}); err == nil {
+		t.Error("exhausted message without dead letter should fail")
+	}
+	if len(s.Messages("queue-a")) != 0 {
+		t.Error("exhausted message should not be resent")
+	}
+}
+
+func TestRetryRouter_DecodeError(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	ring := NewKeyRing()
+	ring.AddKey("k1", make([]byte, 32))
+	p := NewQueueProducer(account.GetQueue("queue-a"))
+	p.SetEncryption(ring)
+	if _, err := p.Send(context.Background(), &ProducerMessage{Body: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 消费端没有密钥，消息无法解密
+	router := NewRetryRouter(NewQueueProducer(account.GetQueue("queue-a")))
+	router.SetDeadLetter(NewQueueProducer(account.GetQueue("queue-dlq")))
+	handled := false
+	consumer := NewConsumer(account.GetQueue("queue-a"), func(ctx context.Context, m *Message) error {
+		handled = true
+		return nil
+	})
+	consumer.SetPollingWaitSeconds(0)
+	consumer.Use(router.Middleware())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.Messages("queue-dlq")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if handled {
+		t.Error("undecodable message should not reach the handler")
+	}
+	if n := len(s.Messages("queue-a")); n != 0 {
+		t.Errorf("original message should be deleted, %d left", n)
+	}
+	dead := s.Messages("queue-dlq")
+	if len(dead) != 1 {
+		t.Fatalf("dead letters = %d, want 1", len(dead))
+	}
+	body, headers, _ := UnwrapBody(dead[0].Body)
+	if headers[HeaderEncryption] != EncryptionAESGCM || headers[HeaderDeadLetterReason] == "" || body == "secret" {
+		t.Errorf("dead letter should keep the encrypted body, got %q %v", body, headers)
+	}
+	ring2 := NewKeyRing()
+	ring2.AddKey("k1", make([]byte, 32))
+	if plain, _, err := DecryptBody(body, headers, ring2); err != nil || plain != "secret" {
+		t.Errorf("DecryptBody() = %q, %v", plain, err)
+	}
+}
+
+func TestRetryRouter_InvalidAttempt(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	router := NewRetryRouter(NewQueueProducer(account.GetQueue("queue-a")))
+	router.SetStageProducers(NewQueueProducer(account.GetQueue("queue-a")))
+	router.SetDeadLetter(NewQueueProducer(account.GetQueue("queue-dlq")))
+	handler := router.Middleware()(func(ctx context.Context, m *Message) error {
+		return errors.New("boom")
+	})
+
+	for i, attempt := range []string{"-3", "abc"} {
+		m := &Message{MsgId: "1", MsgBody: "hello", Headers: map[string]string{HeaderRetryAttempt: attempt}}
+		if err := handler(context.Background(), m); err != nil {
+			t.Fatalf("Retry-Attempt %q: %v", attempt, err)
+		}
+		if n := len(s.Messages("queue-dlq")); n != i+1 {
+			t.Errorf("Retry-Attempt %q: dead letters = %d, want %d", attempt, n, i+1)
+		}
+	}
+	if n := len(s.Messages("queue-a")); n != 0 {
+		t.Errorf("invalid Retry-Attempt should not be retried, resent %d", n)
+	}
+}
@@ -0,0 +1,201 @@
+package cmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 投递时间之后取消记录的保留时间
+const scheduleCancelGrace = 24 * time.Hour
+
+var errInvalidScheduleId = errors.New("invalid schedule id")
+
+// 定时消息的取消记录
+type CancelStore interface {
+	//取消 id，until 之后可以删除取消记录
+	Cancel(ctx context.Context, id string, until time.Time) error
+	//id 是否已经取消
+	Cancelled(ctx context.Context, id string) (bool, error)
+}
+
+// 内存中的取消记录，只能在同一个进程内取消
+type MemoryCancelStore struct {
+	mu        sync.Mutex
+	cancelled map[string]time.Time
+}
+
+func NewMemoryCancelStore() *MemoryCancelStore {
+	return &MemoryCancelStore{cancelled: map[string]time.Time{}}
+}
+
+func (s *MemoryCancelStore) Cancel(ctx context.Context, id string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, u := range s.cancelled {
+		if u.Before(now) {
+			delete(s.cancelled, k)
+		}
+	}
+	s.cancelled[id] = until
+	return nil
+}
+
+func (s *MemoryCancelStore) Cancelled(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.cancelled[id]
+	return ok, nil
+}
+
+// 暂存在中转队列中的定时消息
+type scheduledMessage struct {
+	Id string `json:"id"`
+	//目的地类型：ResourceQueue 或 ResourceTopic
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	//投递时间，Unix 毫秒
+	At      int64            `json:"at"`
+	Message *ProducerMessage `json:"message"`
+}
+
+func (sm *scheduledMessage) time() time.Time {
+	return time.Unix(0, sm.At*int64(time.Millisecond))
+}
+
+// 定时投递任意时间之后的消息
+// 消息先发送到中转队列，每次延时不超过 MaxDelaySeconds，Run 收到还没到时间的消息时再次延时发送，
+// 到时间后发送到目标队列或发布到目标主题。主题本身不支持延时，也可以通过中转队列定时发布。
+// 消息至少投递一次，投递时间误差为秒级
+type Scheduler struct {
+	account *CmqConfig
+	staging *Queue
+	cancels CancelStore
+	now     func() time.Time
+}
+
+// 创建定时投递器，staging 为中转队列，只用于定时消息
+func NewScheduler(staging *Queue) *Scheduler {
+	return &Scheduler{
+		account: staging.client.account,
+		staging: staging,
+		cancels: NewMemoryCancelStore(),
+		now:     time.Now,
+	}
+}
+
+// 设置取消记录的存储，多个进程共享中转队列时需要使用共享的存储
+func (s *Scheduler) SetCancelStore(store CancelStore) {
+	s.cancels = store
+}
+
+// 在 at 时刻把消息发送到队列 queueName，返回定时消息Id，用于取消
+func (s *Scheduler) SendAt(ctx context.Context, queueName string, m *ProducerMessage, at time.Time) (string, *CMQError) {
+	return s.schedule(ctx, ResourceQueue, queueName, m, at)
+}
+
+// 在 at 时刻把消息发布到主题 topicName，返回定时消息Id，用于取消
+func (s *Scheduler) PublishAt(ctx context.Context, topicName string, m *ProducerMessage, at time.Time) (string, *CMQError) {
+	return s.schedule(ctx, ResourceTopic, topicName, m, at)
+}
+
+// 取消还没有投递的定时消息，Run 收到已取消的消息时丢弃
+func (s *Scheduler) Cancel(ctx context.Context, id string) error {
+	i := strings.IndexByte(id, '-')
+	if i <= 0 {
+		return errInvalidScheduleId
+	}
+	ms, err := strconv.ParseInt(id[:i], 10, 64)
+	if err != nil {
+		return errInvalidScheduleId
+	}
+	// 中转队列中的消息可能晚于投递时间才被收到，取消记录多保留一段时间
+	until := time.Unix(0, ms*int64(time.Millisecond)).Add(scheduleCancelGrace)
+	return s.cancels.Cancel(ctx, id, until)
+}
+
+func (s *Scheduler) schedule(ctx context.Context, kind, name string, m *ProducerMessage, at time.Time) (string, *CMQError) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", NewCMQOpError(CMQError100, err, SendMessage)
+	}
+	ms := at.UnixNano() / int64(time.Millisecond)
+	sm := &scheduledMessage{
+		// Id 中带上投递时间，取消记录只需要保留到投递时间之后
+		Id:      strconv.FormatInt(ms, 10) + "-" + hex.EncodeToString(b),
+		Kind:    kind,
+		Name:    name,
+		At:      ms,
+		Message: m,
+	}
+	if err := s.stage(ctx, sm); err != nil {
+		return "", err
+	}
+	return sm.Id, nil
+}
+
+// 把定时消息发送到中转队列，延时到投递时间，最多 MaxDelaySeconds
+func (s *Scheduler) stage(ctx context.Context, sm *scheduledMessage) *CMQError {
+	body, err := json.Marshal(sm)
+	if err != nil {
+		return NewCMQOpError(CMQError100, err, SendMessage)
+	}
+	delay := sm.time().Sub(s.now())
+	seconds := int((delay + time.Second - 1) / time.Second)
+	if seconds < 0 {
+		seconds = 0
+	}
+	if seconds > MaxDelaySeconds {
+		seconds = MaxDelaySeconds
+	}
+	_, cerr := s.staging.WithContext(ctx).SendMessage(string(body), seconds)
+	return cerr
+}
+
+// 消费中转队列，直到 ctx 结束
+func (s *Scheduler) Run(ctx context.Context) error {
+	c := NewConsumer(s.staging, s.handle)
+	return c.Run(ctx)
+}
+
+// 处理中转队列中的一条消息：已取消的丢弃，没到时间的再次延时，到时间的投递
+func (s *Scheduler) handle(ctx context.Context, m *Message) error {
+	var sm scheduledMessage
+	if err := json.Unmarshal([]byte(m.MsgBody), &sm); err != nil || sm.Message == nil {
+		log.Println("drop invalid scheduled message, msgId: " + m.MsgId)
+		return nil
+	}
+	cancelled, err := s.cancels.Cancelled(ctx, sm.Id)
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		log.Println("drop cancelled scheduled message, id: " + sm.Id)
+		return nil
+	}
+	if sm.time().After(s.now()) {
+		if err := s.stage(ctx, &sm); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	var p *Producer
+	if sm.Kind == ResourceTopic {
+		p = NewTopicProducer(s.account.GetTopic(sm.Name))
+	} else {
+		p = NewQueueProducer(s.account.GetQueue(sm.Name))
+	}
+	if _, err := p.Send(ctx, sm.Message); err != nil {
+		return err
+	}
+	return nil
+}
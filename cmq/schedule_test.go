@@ -0,0 +1,77 @@
+package cmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestScheduler_SendAt(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	sched := NewScheduler(account.GetQueue("staging"))
+	now := time.Unix(1700000000, 0)
+	sched.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	at := now.Add(2*time.Hour + 30*time.Minute)
+	id, err := sched.SendAt(ctx, "queue-a", &ProducerMessage{Body: "hello"}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) == 0 {
+		t.Fatal("empty schedule id")
+	}
+
+	// 每次收到中转消息时前进一小时，到时间后才投递到目标队列
+	for i := 1; i <= 3; i++ {
+		staged := s.Messages("staging")
+		if len(staged) != i {
+			t.Fatalf("round %d: staged %d messages, want %d", i, len(staged), i)
+		}
+		if len(s.Messages("queue-a")) != 0 {
+			t.Fatalf("round %d: delivered before %v", i, at)
+		}
+		now = now.Add(time.Hour)
+		m := &Message{MsgId: staged[i-1].MsgId, MsgBody: staged[i-1].Body}
+		if err := sched.handle(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	delivered := s.Messages("queue-a")
+	if len(delivered) != 1 || delivered[0].Body != "hello" {
+		t.Fatalf("delivered %+v", delivered)
+	}
+	if len(s.Messages("staging")) != 3 {
+		t.Errorf("due message should not be staged again")
+	}
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	account := NewAccountDefault(s.URL, "id", "key")
+	sched := NewScheduler(account.GetQueue("staging"))
+
+	ctx := context.Background()
+	id, err := sched.PublishAt(ctx, "topic-a", &ProducerMessage{Body: "hello"}, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sched.Cancel(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	staged := s.Messages("staging")[0]
+	if err := sched.handle(ctx, &Message{MsgId: staged.MsgId, MsgBody: staged.Body}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Published("topic-a")) != 0 {
+		t.Error("cancelled message was published")
+	}
+	if err := sched.Cancel(ctx, "unknown"); err != errInvalidScheduleId {
+		t.Errorf("Cancel() = %v, want errInvalidScheduleId", err)
+	}
+}
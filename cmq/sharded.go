@@ -0,0 +1,282 @@
+package cmq
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//一致性哈希环上每个分片的虚拟节点数
+	shardVirtualNodes = 64
+	//ShardedConsumer 检查已移除分片是否消费完的间隔
+	shardDrainInterval = 5 * time.Second
+)
+
+type shardPoint struct {
+	hash  uint32
+	shard string
+}
+
+// 由多个物理队列组成的逻辑队列，用于突破单个队列的吞吐上限
+// 带分片键的消息按一致性哈希发送到固定的分片，增删分片时只有少量分片键改变分片；
+// 分片键为空的消息轮询发送到各个分片。分片之间不保证消息顺序
+type ShardedQueue struct {
+	//轮询计数，放在第一个字段保证 32 位平台上的原子操作对齐
+	next        uint64
+	account     *CmqConfig
+	newProducer func(q *Queue) *Producer
+	metrics     Metrics
+
+	mu        sync.RWMutex
+	shards    []string
+	ring      []shardPoint
+	producers map[string]*Producer
+	//分片变化时关闭并替换，通知 ShardedConsumer
+	changed chan struct{}
+}
+
+// 创建分片队列，queueNames 为已经创建好的物理队列
+func NewShardedQueue(account *CmqConfig, queueNames ...string) *ShardedQueue {
+	s := &ShardedQueue{
+		account:     account,
+		newProducer: NewQueueProducer,
+		producers:   map[string]*Producer{},
+		changed:     make(chan struct{}),
+	}
+	for _, name := range queueNames {
+		s.AddShard(name)
+	}
+	return s
+}
+
+// 设置创建分片生产者的函数，用于配置压缩、加密等；需要在发送消息前设置
+func (s *ShardedQueue) SetProducerFactory(f func(q *Queue) *Producer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.newProducer = f
+	s.producers = map[string]*Producer{}
+}
+
+// 设置监控指标，分片的生产者和 ShardedConsumer 的消费者都使用这个指标，按分片的队列名区分
+func (s *ShardedQueue) SetMetrics(m Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = m
+	s.producers = map[string]*Producer{}
+}
+
+// 当前的分片队列名
+func (s *ShardedQueue) Shards() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.shards...)
+}
+
+// 增加分片，已经存在时什么也不做
+func (s *ShardedQueue) AddShard(queueName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range s.shards {
+		if name == queueName {
+			return
+		}
+	}
+	s.shards = append(s.shards, queueName)
+	s.rebuild()
+}
+
+// 移除分片，之后不再向这个分片发送消息；运行中的 ShardedConsumer 消费完分片中剩余的消息后停止消费这个分片
+func (s *ShardedQueue) RemoveShard(queueName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, name := range s.shards {
+		if name == queueName {
+			s.shards = append(s.shards[:i], s.shards[i+1:]...)
+			delete(s.producers, queueName)
+			s.rebuild()
+			return true
+		}
+	}
+	return false
+}
+
+// 重建哈希环并通知分片变化，调用时持有写锁
+func (s *ShardedQueue) rebuild() {
+	ring := make([]shardPoint, 0, len(s.shards)*shardVirtualNodes)
+	for _, name := range s.shards {
+		for i := 0; i < shardVirtualNodes; i++ {
+			ring = append(ring, shardPoint{hash: shardHash(name + "#" + strconv.Itoa(i)), shard: name})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	s.ring = ring
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func shardHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// 分片键 key 对应的分片队列名，key 为空时轮询；没有分片时返回空字符串
+func (s *ShardedQueue) Shard(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shard(key)
+}
+
+func (s *ShardedQueue) shard(key string) string {
+	if len(s.shards) == 0 {
+		return ""
+	}
+	if len(key) == 0 {
+		n := atomic.AddUint64(&s.next, 1)
+		return s.shards[(n-1)%uint64(len(s.shards))]
+	}
+	h := shardHash(key)
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+// 按分片键 key 发送一条消息，返回消息Id
+func (s *ShardedQueue) Send(ctx context.Context, key string, m *ProducerMessage) (string, *CMQError) {
+	p, err := s.producer(key)
+	if err != nil {
+		return "", err
+	}
+	return p.Send(ctx, m)
+}
+
+func (s *ShardedQueue) producer(key string) (*Producer, *CMQError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.shard(key)
+	if len(name) == 0 {
+		return nil, NewCMQOpError(CMQError100, &ValidationError{Field: "shards", Reason: "no shard"}, SendMessage)
+	}
+	p, ok := s.producers[name]
+	if !ok {
+		p = s.newProducer(s.account.GetQueue(name))
+		if s.metrics != nil {
+			p.SetMetrics(s.metrics)
+		}
+		s.producers[name] = p
+	}
+	return p, nil
+}
+
+// 每个分片的消息积压情况
+func (s *ShardedQueue) Backlog(ctx context.Context) ([]*Backlog, *CMQError) {
+	m := s.account.NewMonitor(0)
+	m.AddQueue(s.Shards()...)
+	return m.Poll(ctx)
+}
+
+func (s *ShardedQueue) snapshot() ([]string, <-chan struct{}) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.shards...), s.changed
+}
+
+// 分片队列的消费者，每个分片一个 Consumer，各自拉取消息，分片之间互不影响
+// 分片增加时自动开始消费，分片移除后消费完剩余的消息再停止
+type ShardedConsumer struct {
+	queue   *ShardedQueue
+	handler Handler
+	setup   func(c *Consumer)
+	//检查已移除分片是否消费完的间隔
+	drainInterval time.Duration
+}
+
+func NewShardedConsumer(s *ShardedQueue, handler Handler) *ShardedConsumer {
+	return &ShardedConsumer{queue: s, handler: handler, drainInterval: shardDrainInterval}
+}
+
+// 设置配置每个分片 Consumer 的函数，比如并发数、中间件；并发数对每个分片单独生效
+func (c *ShardedConsumer) SetConsumerSetup(setup func(c *Consumer)) {
+	c.setup = setup
+}
+
+type shardRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// 开始消费所有分片，直到 ctx 结束
+func (c *ShardedConsumer) Run(ctx context.Context) error {
+	running := map[string]*shardRun{}
+	ticker := time.NewTicker(c.drainInterval)
+	defer ticker.Stop()
+	for {
+		shards, changed := c.queue.snapshot()
+		current := map[string]bool{}
+		for _, name := range shards {
+			current[name] = true
+			if _, ok := running[name]; !ok {
+				running[name] = c.start(ctx, name)
+			}
+		}
+		for name, r := range running {
+			if !current[name] && c.drained(ctx, name) {
+				r.cancel()
+				<-r.done
+				delete(running, name)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, r := range running {
+				<-r.done
+			}
+			return ctx.Err()
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *ShardedConsumer) start(ctx context.Context, queueName string) *shardRun {
+	consumer := NewConsumer(c.queue.account.GetQueue(queueName), c.handler)
+	c.queue.mu.RLock()
+	if c.queue.metrics != nil {
+		consumer.SetMetrics(c.queue.metrics)
+	}
+	c.queue.mu.RUnlock()
+	if c.setup != nil {
+		c.setup(consumer)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r := &shardRun{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		consumer.Run(ctx)
+	}()
+	return r
+}
+
+// 已移除的分片是否没有剩余的消息
+func (c *ShardedConsumer) drained(ctx context.Context, queueName string) bool {
+	m := c.queue.account.NewMonitor(0)
+	b, err := m.queueBacklog(ctx, queueName)
+	if err != nil {
+		log.Println("get shard backlog error, queue: " + queueName + ", msg: " + err.Error())
+		return false
+	}
+	return b.Total() == 0
+}
@@ -0,0 +1,120 @@
+package cmq
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestShardedQueue_Shard(t *testing.T) {
+	s := NewShardedQueue(NewAccountDefault("http://localhost", "id", "key"), "q-0", "q-1", "q-2")
+
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		counts[s.Shard("")]++
+	}
+	for _, name := range s.Shards() {
+		if counts[name] != 2 {
+			t.Errorf("round robin sent %d messages to %s, want 2", counts[name], name)
+		}
+	}
+
+	before := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		key := "account-" + strconv.Itoa(i)
+		before[key] = s.Shard(key)
+		if s.Shard(key) != before[key] {
+			t.Fatalf("key %s moved between shards", key)
+		}
+	}
+	s.AddShard("q-3")
+	moved := 0
+	for key, shard := range before {
+		if now := s.Shard(key); now != shard {
+			if now != "q-3" {
+				t.Fatalf("key %s moved from %s to %s, want q-3", key, shard, now)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 400 {
+		t.Errorf("%d of 1000 keys moved to the new shard", moved)
+	}
+
+	if !s.RemoveShard("q-3") || s.RemoveShard("q-3") {
+		t.Error("RemoveShard should remove the shard once")
+	}
+	for key, shard := range before {
+		if s.Shard(key) != shard {
+			t.Fatalf("key %s did not move back to %s", key, shard)
+		}
+	}
+}
+
+func TestShardedConsumer_Run(t *testing.T) {
+	srv := cmqtest.NewServer()
+	defer srv.Close()
+	account := NewAccountDefault(srv.URL, "id", "key")
+	s := NewShardedQueue(account, "q-0", "q-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	handled := map[string]string{}
+	c := NewShardedConsumer(s, func(ctx context.Context, m *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled[m.MsgBody] = m.MsgId
+		return nil
+	})
+	c.drainInterval = 10 * time.Millisecond
+	c.SetConsumerSetup(func(c *Consumer) {
+		c.SetPollingWaitSeconds(0)
+	})
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+
+	waitHandled := func(n int) {
+		deadline := time.Now().Add(5 * time.Second)
+		got := 0
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			got = len(handled)
+			mu.Unlock()
+			if got >= n {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("handled %d messages, want %d", got, n)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := s.Send(ctx, "", &ProducerMessage{Body: "m-" + strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitHandled(4)
+	if len(srv.Messages("q-0")) != 0 || len(srv.Messages("q-1")) != 0 {
+		t.Error("all shards should be consumed")
+	}
+
+	// 新增的分片自动开始消费，移除的分片消费完剩余的消息
+	s.AddShard("q-2")
+	srv.Enqueue("q-1", "left")
+	s.RemoveShard("q-1")
+	if _, err := s.Send(ctx, "", &ProducerMessage{Body: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	waitHandled(6)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop")
+	}
+}
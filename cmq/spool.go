@@ -0,0 +1,416 @@
+package cmq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//单个 spool 文件的最大字节数，超过后写入新文件
+	spoolSegmentSize = 4 << 20
+	spoolSegmentExt  = ".spool"
+	spoolCursorFile  = "cursor"
+)
+
+var errSpoolFull = errors.New("spool is full")
+
+// spool 监控指标，cmqprom.Metrics 实现了这个接口
+type SpoolMetrics interface {
+	//spool 中待转发的消息数和文件字节数
+	SetSpoolDepth(resource string, messages int, bytes int64)
+	//超过最长保留时间或被服务端拒绝而丢弃的消息数
+	AddSpoolDropped(resource string, n int)
+}
+
+type spoolRecord struct {
+	//写入 spool 的时间，UnixNano
+	Time int64 `json:"time"`
+	//按 Producer 的设置压缩、加密之后的消息，转发时不再编码
+	Message *ProducerMessage `json:"message"`
+}
+
+// 转发进度：下一条待转发消息所在的文件序号和偏移量
+type spoolCursor struct {
+	Segment int   `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// 本地磁盘上的消息暂存，Producer 发送失败时写入，由 Producer.RunForwarder 按顺序转发
+// 消息按行追加到 dir 目录下的文件中，转发进度保存在 cursor 文件里，进程重启后继续转发
+type Spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	metrics  SpoolMetrics
+	resource string
+	notify   chan struct{}
+
+	mu       sync.Mutex
+	segments []int
+	writer   *os.File
+	size     int64
+	bytes    int64
+	depth    int
+	cursor   spoolCursor
+}
+
+// 打开或创建 dir 目录下的 spool，已有的未转发消息会被保留
+func NewSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		dir:    dir,
+		notify: make(chan struct{}, 1),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 设置 spool 文件的总字节数上限，超过后不再写入，0 表示不限制
+func (s *Spool) SetMaxSize(maxBytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxBytes = maxBytes
+}
+
+// 设置消息在 spool 中的最长保留时间，超过后转发时丢弃，0 表示不限制
+func (s *Spool) SetMaxAge(maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAge = maxAge
+}
+
+// 设置监控指标
+func (s *Spool) SetMetrics(m SpoolMetrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = m
+	s.reportDepth()
+}
+
+// 待转发的消息数
+func (s *Spool) Depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depth
+}
+
+// 关闭正在写入的文件
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writer == nil {
+		return nil
+	}
+	err := s.writer.Close()
+	s.writer = nil
+	return err
+}
+
+// 读取已有的文件和转发进度，统计待转发的消息数
+func (s *Spool) load() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), spoolSegmentExt) {
+			if n, err := strconv.Atoi(strings.TrimSuffix(f.Name(), spoolSegmentExt)); err == nil {
+				s.segments = append(s.segments, n)
+				s.bytes += f.Size()
+			}
+		}
+	}
+	sort.Ints(s.segments)
+
+	if data, err := ioutil.ReadFile(filepath.Join(s.dir, spoolCursorFile)); err == nil {
+		if err := json.Unmarshal(data, &s.cursor); err != nil {
+			return fmt.Errorf("invalid spool cursor: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if len(s.segments) > 0 && s.cursor.Segment < s.segments[0] {
+		s.cursor = spoolCursor{Segment: s.segments[0]}
+	}
+
+	if len(s.segments) > 0 {
+		// 上次写入中断时最后一行可能不完整，截掉
+		last := s.segmentPath(s.segments[len(s.segments)-1])
+		if err := truncatePartialLine(last); err != nil {
+			return err
+		}
+	}
+	for _, seg := range s.segments {
+		n, err := s.countLines(seg)
+		if err != nil {
+			return err
+		}
+		s.depth += n
+	}
+	return nil
+}
+
+func (s *Spool) countLines(seg int) (int, error) {
+	f, err := os.Open(s.segmentPath(seg))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if seg == s.cursor.Segment {
+		if _, err := f.Seek(s.cursor.Offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	} else if seg < s.cursor.Segment {
+		return 0, nil
+	}
+	n := 0
+	r := bufio.NewReader(f)
+	for {
+		_, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+}
+
+func truncatePartialLine(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i+1 != len(data) {
+		return os.Truncate(path, int64(i+1))
+	}
+	return nil
+}
+
+func (s *Spool) segmentPath(seg int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seg, spoolSegmentExt))
+}
+
+// 追加一条消息
+func (s *Spool) append(m *ProducerMessage) error {
+	line, err := json.Marshal(&spoolRecord{Time: time.Now().UnixNano(), Message: m})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && s.bytes+int64(len(line)) > s.maxBytes {
+		return errSpoolFull
+	}
+	if s.writer == nil || s.size+int64(len(line)) > spoolSegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.writer.Write(line); err != nil {
+		return err
+	}
+	if err := s.writer.Sync(); err != nil {
+		return err
+	}
+	s.size += int64(len(line))
+	s.bytes += int64(len(line))
+	s.depth++
+	s.reportDepth()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// 打开新的文件用于写入；第一次写入时继续写最后一个文件
+func (s *Spool) rotate() error {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	seg := s.cursor.Segment
+	if seg < 1 {
+		seg = 1
+	}
+	if n := len(s.segments); n > 0 {
+		seg = s.segments[n-1]
+		if info, err := os.Stat(s.segmentPath(seg)); err != nil || info.Size() >= spoolSegmentSize {
+			seg++
+		}
+	}
+	f, err := os.OpenFile(s.segmentPath(seg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if n := len(s.segments); n == 0 || s.segments[n-1] != seg {
+		s.segments = append(s.segments, seg)
+	}
+	if len(s.segments) == 1 && s.cursor.Segment != seg {
+		s.cursor = spoolCursor{Segment: seg}
+	}
+	s.writer = f
+	s.size = info.Size()
+	return nil
+}
+
+// 读取下一条待转发的消息和读取之后的进度，没有消息时返回 nil
+func (s *Spool) peek() (*spoolRecord, spoolCursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.depth > 0 {
+		f, err := os.Open(s.segmentPath(s.cursor.Segment))
+		if err != nil {
+			return nil, s.cursor, err
+		}
+		line, err := readLineAt(f, s.cursor.Offset)
+		f.Close()
+		if err == io.EOF {
+			// 当前文件已经转发完，删除并切换到下一个文件
+			if err := s.removeSegment(s.cursor.Segment); err != nil {
+				return nil, s.cursor, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, s.cursor, err
+		}
+		next := spoolCursor{Segment: s.cursor.Segment, Offset: s.cursor.Offset + int64(len(line))}
+		var rec spoolRecord
+		if err := json.Unmarshal(line, &rec); err != nil || rec.Message == nil {
+			// 损坏的记录直接跳过
+			if err := s.advance(next); err != nil {
+				return nil, s.cursor, err
+			}
+			continue
+		}
+		return &rec, next, nil
+	}
+	return nil, s.cursor, nil
+}
+
+func readLineAt(f *os.File, offset int64) ([]byte, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		// 还没有写完的行
+		return nil, io.EOF
+	}
+	return line, err
+}
+
+func (s *Spool) removeSegment(seg int) error {
+	if len(s.segments) <= 1 || s.segments[0] != seg {
+		// 消息数和文件内容不一致，以文件为准
+		s.depth = 0
+		s.reportDepth()
+		return nil
+	}
+	if info, err := os.Stat(s.segmentPath(seg)); err == nil {
+		s.bytes -= info.Size()
+	}
+	if err := os.Remove(s.segmentPath(seg)); err != nil {
+		return err
+	}
+	s.segments = s.segments[1:]
+	s.cursor = spoolCursor{Segment: s.segments[0]}
+	return s.saveCursor()
+}
+
+// 一条消息已经转发或丢弃，保存进度
+func (s *Spool) commit(next spoolCursor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.advance(next)
+}
+
+func (s *Spool) advance(next spoolCursor) error {
+	s.cursor = next
+	s.depth--
+	if s.depth <= 0 {
+		return s.reset()
+	}
+	s.reportDepth()
+	return s.saveCursor()
+}
+
+// 所有消息都已转发，删除文件，下次写入时使用新的文件
+func (s *Spool) reset() error {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	for _, seg := range s.segments {
+		if err := os.Remove(s.segmentPath(seg)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if n := len(s.segments); n > 0 {
+		s.cursor = spoolCursor{Segment: s.segments[n-1] + 1}
+	}
+	s.segments = nil
+	s.size = 0
+	s.bytes = 0
+	s.depth = 0
+	s.reportDepth()
+	return s.saveCursor()
+}
+
+func (s *Spool) saveCursor() error {
+	data, _ := json.Marshal(&s.cursor)
+	tmp := filepath.Join(s.dir, spoolCursorFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, spoolCursorFile))
+}
+
+func (s *Spool) reportDepth() {
+	if s.metrics != nil {
+		s.metrics.SetSpoolDepth(s.resource, s.depth, s.bytes)
+	}
+}
+
+func (s *Spool) dropped(n int) {
+	s.mu.Lock()
+	m := s.metrics
+	s.mu.Unlock()
+	if m != nil {
+		m.AddSpoolDropped(s.resource, n)
+	}
+}
+
+// 消息是否已经超过最长保留时间
+func (s *Spool) expired(rec *spoolRecord) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxAge > 0 && time.Since(time.Unix(0, rec.Time)) > s.maxAge
+}
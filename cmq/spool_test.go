@@ -0,0 +1,163 @@
+package cmq
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+type spoolMetrics struct {
+	messages int
+	bytes    int64
+	dropped  int
+}
+
+func (m *spoolMetrics) SetSpoolDepth(resource string, messages int, bytes int64) {
+	m.messages = messages
+	m.bytes = bytes
+}
+
+func (m *spoolMetrics) AddSpoolDropped(resource string, n int) {
+	m.dropped += n
+}
+
+func TestProducer_Spool(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	dir := t.TempDir()
+
+	account := NewAccountDefault(deadEndpoint(), "id", "key")
+	spool, err := NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewQueueProducer(account.GetQueue("queue-a"))
+	p.SetSpool(spool)
+	for _, body := range []string{"a", "b", "c"} {
+		if msgId, err := p.Send(context.Background(), &ProducerMessage{Body: body}); err != nil || msgId != "" {
+			t.Fatalf("Send(%s) = %q, %v", body, msgId, err)
+		}
+	}
+	spool.Close()
+
+	// 重新打开后保留未转发的消息
+	spool, err = NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	if n := spool.Depth(); n != 3 {
+		t.Fatalf("Depth() = %d after reopen", n)
+	}
+	m := &spoolMetrics{}
+	spool.SetMetrics(m)
+
+	account.SetQueueEndpoint(s.URL)
+	p = NewQueueProducer(account.GetQueue("queue-a"))
+	p.SetSpool(spool)
+	// spool 中还有消息时，新消息排在后面
+	if _, err := p.Send(context.Background(), &ProducerMessage{Body: "d"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.RunForwarder(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for spool.Depth() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	msgs := s.Messages("queue-a")
+	if len(msgs) != 4 {
+		t.Fatalf("forwarded %d messages", len(msgs))
+	}
+	for i, body := range []string{"a", "b", "c", "d"} {
+		if msgs[i].Body != body {
+			t.Errorf("message %d = %q, want %q", i, msgs[i].Body, body)
+		}
+	}
+	if m.messages != 0 || m.bytes != 0 {
+		t.Errorf("metrics = %+v", m)
+	}
+}
+
+func TestSpool_MaxSize(t *testing.T) {
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	spool.SetMaxSize(150)
+
+	if err := spool.append(&ProducerMessage{Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.append(&ProducerMessage{Body: "hello"}); err != errSpoolFull {
+		t.Errorf("append() = %v, want errSpoolFull", err)
+	}
+}
+
+func TestSpool_MaxAge(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	m := &spoolMetrics{}
+	spool.SetMetrics(m)
+	spool.SetMaxAge(time.Millisecond)
+	if err := spool.append(&ProducerMessage{Body: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	p := NewQueueProducer(NewAccountDefault(s.URL, "id", "key").GetQueue("queue-a"))
+	p.SetSpool(spool)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	p.RunForwarder(ctx)
+
+	if n := len(s.Messages("queue-a")); n != 0 {
+		t.Errorf("forwarded %d expired messages", n)
+	}
+	if m.dropped != 1 || spool.Depth() != 0 {
+		t.Errorf("dropped = %d, depth = %d", m.dropped, spool.Depth())
+	}
+}
+
+func TestProducer_SpoolEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	ring := NewKeyRing()
+	ring.AddKey("k1", make([]byte, 32))
+
+	p := NewQueueProducer(NewAccountDefault(deadEndpoint(), "id", "key").GetQueue("queue-a"))
+	p.SetEncryption(ring)
+	p.SetSpool(spool)
+	if _, err := p.Send(context.Background(), &ProducerMessage{Body: "secret-card-number"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if strings.Contains(string(data), "secret-card-number") {
+			t.Errorf("plaintext written to spool file %s", f.Name())
+		}
+	}
+}
@@ -1,16 +1,24 @@
 package cmq
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"errors"
-	"strconv"
 	"log"
 )
 
 const (
 	NotifyStrategyDefault 				= 	"BACKOFF_RETRY"
+	//指数衰退重试
+	NotifyStrategyExponentialDecay		=	"EXPONENTIAL_DECAY_RETRY"
 	NotifyContentFormatDefault			=	"JSON"
+	//raw 格式，protocol 为 queue 时必须使用
+	NotifyContentFormatSimplified		=	"SIMPLIFIED"
+	//订阅协议：推送到用户的 web server
+	ProtocolHttp						=	"http"
+	//订阅协议：推送到 CMQ queue
+	ProtocolQueue						=	"queue"
 	ClearSUbscriptionFIlterTags 		=	"ClearSUbscriptionFIlterTags"
 	SetSubscriptionAttributes			=	"SetSubscriptionAttributes"
 	GetSubscriptionAttributes			=	"GetSubscriptionAttributes"
@@ -23,6 +31,23 @@ type Subscription struct {
 	client *Client
 }
 
+//返回使用ctx发起调用的Subscription
+//ctx用于取消请求，并传递给拦截器（比如链路追踪信息）
+func (this *Subscription) WithContext(ctx context.Context) *Subscription {
+	return &Subscription{
+		topicName:this.topicName,
+		subscriptionName:this.subscriptionName,
+		client:this.client.withContext(ctx),
+	}
+}
+
+func (this *Subscription) request() *subscriptionRequest {
+	return &subscriptionRequest{
+		TopicName:this.topicName,
+		SubscriptionName:this.subscriptionName,
+	}
+}
+
 type SubscriptionMeta struct {
 	//Subscription 订阅的主题所有者的appId
 	TopicOwner 			string
@@ -45,6 +70,23 @@ type SubscriptionMeta struct {
 	BindingKey			[]string
 }
 
+type subscriptionAttributes struct {
+	Code int							`json:"code"`
+	Message string						`json:"message"`
+	RequestId string 					`json:"requestId"`
+	//appId，可能是数字也可能是字符串
+	TopicOwner jsonString				`json:"topicOwner"`
+	MsgCount int						`json:"msgCount"`
+	Protocol string						`json:"protocol"`
+	Endpoint string						`json:"endpoint"`
+	NotifyStrategy string				`json:"notifyStrategy"`
+	NotifyContentFormat string			`json:"notifyContentFormat"`
+	CreateTime int						`json:"createTime"`
+	LastModifyTime int					`json:"lastModifyTime"`
+	FilterTag []string					`json:"filterTag"`
+	BindingKey []string					`json:"bindingKey"`
+}
+
 type SubscriptionResult struct {
 	Code int								`json:"code"`
 	Message string							`json:"message"`
@@ -61,114 +103,84 @@ type SubscriptionList struct {
 }
 
 func (this *Subscription) ClearFilterTags() *CMQError {
-
-	params := map[string]interface{} {
-		"topicName" : this.topicName,
-		"subscriptionName" : this.subscriptionName,
+	if err := this.validate();err != nil {
+		return NewCMQOpError(CMQError100,err,ClearSUbscriptionFIlterTags)
 	}
 
-	return handleSubscriptionApi(this,ClearSUbscriptionFIlterTags,params)
+	return handleSubscriptionApi(this,ClearSUbscriptionFIlterTags,this.request())
 }
 
 // 修改订阅属性
 func (this *Subscription) SetSubscriptionAttributes(meta SubscriptionMeta) *CMQError {
-	params := map[string]interface{} {
-		"topicName" : this.topicName,
-		"subscriptionName" : this.subscriptionName,
+	if err := firstInvalid(
+		this.validate(),
+		validateTags("filterTag",meta.FilterTag),
+		validateBindingKeys("bindingKey",meta.BindingKey));err != nil {
+		return NewCMQOpError(CMQError100,err,SetSubscriptionAttributes)
 	}
-
 	if len(meta.NotifyStrategy) != 0 {
-		params["notifyStrategy"] = meta.NotifyStrategy
+		if err := validateOneOf("notifyStrategy",meta.NotifyStrategy,NotifyStrategyDefault,NotifyStrategyExponentialDecay);err != nil {
+			return NewCMQOpError(CMQError100,err,SetSubscriptionAttributes)
+		}
 	}
-
 	if len(meta.NotifyContentFormat) != 0 {
-		params["notifyContentFormat"] = meta.NotifyContentFormat
-	}
-
-	for i,ft := range meta.FilterTag {
-		params["filterTag." + strconv.Itoa(i+1)] = ft
-	}
-
-	for i,bk := range meta.BindingKey {
-		params["bindingKey." + strconv.Itoa(i+1)] = bk
+		if err := validateNotifyContentFormat(meta.Protocal,meta.NotifyContentFormat);err != nil {
+			return NewCMQOpError(CMQError100,err,SetSubscriptionAttributes)
+		}
+		// 调用方一般不设置 Protocal，queue 协议只支持 SIMPLIFIED，需要先查询订阅的协议
+		if len(meta.Protocal) == 0 && meta.NotifyContentFormat != NotifyContentFormatSimplified {
+			current, err := this.GetSubscriptionAttributes()
+			if err != nil {
+				return err
+			}
+			if err := validateNotifyContentFormat(current.Protocal,meta.NotifyContentFormat);err != nil {
+				return NewCMQOpError(CMQError100,err,SetSubscriptionAttributes)
+			}
+		}
 	}
-
-	return handleSubscriptionApi(this,SetSubscriptionAttributes,params)
+	return handleSubscriptionApi(this,SetSubscriptionAttributes,&subscriptionAttributesRequest{
+		TopicName:this.topicName,
+		SubscriptionName:this.subscriptionName,
+		NotifyStrategy:meta.NotifyStrategy,
+		NotifyContentFormat:meta.NotifyContentFormat,
+		FilterTag:meta.FilterTag,
+		BindingKey:meta.BindingKey,
+	})
 }
 
 // 获取订阅属性
 func (this *Subscription) GetSubscriptionAttributes() (*SubscriptionMeta,*CMQError) {
-
-	params := map[string]interface{} {
-		"topicName" : this.topicName,
-		"subscriptionName" : this.subscriptionName,
+	if err := this.validate();err != nil {
+		return nil,NewCMQOpError(CMQError100,err,GetSubscriptionAttributes)
 	}
 
-	result, err := this.client.cmqCall(GetSubscriptionAttributes, params)
+	result, err := this.client.call(GetSubscriptionAttributes, this.request())
 	if err != nil {
 		log.Println("create queue error msg: " + err.Error())
 		return nil,err
 	}
-	var res map[string]interface{}
+	var res subscriptionAttributes
 	if err := json.Unmarshal([]byte(result),&res);err != nil {
 		log.Println("parse json string error, msg: " + err.Error())
 		return nil,NewCMQOpError(CMQError102,jsonUnmarshal,GetSubscriptionAttributes)
 	}
-	code := res["code"].(int)
+	code := res.Code
 	if code != 0 {
-		log.Println(fmt.Sprintf("code:%d, %v, RequestId: %v",code,res["message"],res["requestId"]))
-		return nil,NewCMQOpError(erron(code),errors.New(res["message"].(string)),GetSubscriptionAttributes)
-	}
-
-	var meta *SubscriptionMeta
-
-	endpoint,ok := res["endpoint"].(string)
-	if ok && len(endpoint) != 0 {
-		meta.Endpoint = endpoint
+		log.Println(fmt.Sprintf("code:%d, %v, RequestId: %v",code,res.Message,res.RequestId))
+		return nil,NewCMQOpError(erron(code),errors.New(res.Message),GetSubscriptionAttributes)
 	}
 
-	notifyStrategy,ok := res["notifyStrategy"].(string)
-	if ok && len(notifyStrategy) != 0 {
-		meta.NotifyStrategy = notifyStrategy
-	}
-
-	notifyContentFormat,ok := res["notifyContentFormat"].(string)
-	if ok && len(notifyContentFormat) !=0 {
-		meta.NotifyContentFormat = notifyContentFormat
-	}
-
-	protocol,ok := res["protocol"].(string)
-	if ok && len(protocol) != 0 {
-		meta.Protocal = protocol
-	}
-
-	createTime,ok := res["createTime"].(int)
-	if ok {
-		meta.CreateTime = createTime
-	}
-
-	lastModifyTime,ok := res["lastModifyTime"].(int)
-	if ok {
-		meta.LastModifyTime = lastModifyTime
-	}
-
-	msgCount,ok := res["msgCount"].(int)
-	if ok {
-		meta.MsgCount = msgCount
-	}
-
-	fts,ok := res["filterTag"].([]string)
-	if ok && fts != nil && len(fts) != 0 {
-		for i,ft := range fts {
-			meta.FilterTag[i] = ft
-		}
-	}
-
-	bks,ok := res["bindingKey"].([]string)
-	if ok && bks != nil && len(bks) != 0 {
-		for i,bk := range bks {
-			meta.BindingKey[i] = bk
-		}
+	meta := &SubscriptionMeta{
+		Endpoint:res.Endpoint,
+		Protocal:res.Protocol,
+		NotifyStrategy:res.NotifyStrategy,
+		NotifyContentFormat:res.NotifyContentFormat,
+		CreateTime:res.CreateTime,
+		LastModifyTime:res.LastModifyTime,
+		MsgCount:res.MsgCount,
+		FilterTag:res.FilterTag,
+		BindingKey:res.BindingKey,
+		TopicOwner:string(res.TopicOwner),
 	}
 
 	return meta,nil
@@ -178,47 +190,57 @@ func (this *Subscription) GetSubscriptionAttributes() (*SubscriptionMeta,*CMQErr
 // offset 分页时本页获取订阅列表的起始位置。如果填写了该值，必须也要填写 limit。该值缺省时，后台取默认值 0。取值范围 0-1000。
 // limit 分页时本页获取订阅的个数，该参数取值范围 0-100。如果不传递该参数，则该参数默认为 20。
 func (this *Subscription) ListSubscription(offset,limit int,searchWord string,vSubscriptionList []string) (int,*CMQError) {
-	params := map[string]interface{} {
-		"topicName":this.topicName,
+	sr, err := listSubscriptions(this.client,this.topicName,offset,limit,searchWord)
+	if err != nil {
+		return 0,err
 	}
-	if len(searchWord) != 0 {
-		params["searchWord"] = searchWord
+
+	if vSubscriptionList != nil {
+		for i,sl := range sr.SubscriptionList {
+			vSubscriptionList[i] = sl.SubscriptionName
+		}
 	}
-	if offset >= 0 {
-		params["offset"] = offset
+
+	return sr.TotalCount,nil
+}
+
+func listSubscriptions(client *Client,topicName string,offset,limit int,searchWord string) (*SubscriptionResult,*CMQError) {
+	if err := validateName("topicName",topicName);err != nil {
+		return nil,NewCMQOpError(CMQError100,err,ListSubscriptionByTopic)
 	}
-	if limit >= 0 {
-		params["limit"] = limit
+	req := newListRequest(searchWord,offset,limit)
+	req.TopicName = topicName
+	if limit == 0 {
+		req.Limit = &limit
 	}
-	result, err := this.client.cmqCall(ListSubscriptionByTopic, params)
+	result, err := client.call(ListSubscriptionByTopic, req)
 	if err != nil {
 		log.Println("create queue error msg: " + err.Error())
-		return 0,err
+		return nil,err
 	}
 
 	var sr SubscriptionResult
 	if err := json.Unmarshal([]byte(result),&sr);err != nil {
 		log.Println("parse json string error, msg: " + err.Error())
-		return 0,NewCMQOpError(CMQError102,jsonUnmarshal,ListSubscriptionByTopic)
+		return nil,NewCMQOpError(CMQError102,jsonUnmarshal,ListSubscriptionByTopic)
 	}
 
 	code := sr.Code
 	if code != 0 {
 		log.Println(fmt.Sprintf("code:%d, %v, RequestId: %v",code,sr.Message,sr.RequestId))
-		return 0,NewCMQOpError(erron(code),errors.New(sr.Message),ListSubscriptionByTopic)
+		return nil,NewCMQOpError(erron(code),errors.New(sr.Message),ListSubscriptionByTopic)
 	}
 
-	if vSubscriptionList != nil {
-		for i,sl := range sr.SubscriptionList {
-			vSubscriptionList[i] = sl.SubscriptionName
-		}
-	}
+	return &sr,nil
+}
 
-	return sr.TotalCount,nil
+// 校验主题名称和订阅名称
+func (this *Subscription) validate() *ValidationError {
+	return firstInvalid(validateName("topicName",this.topicName),validateName("subscriptionName",this.subscriptionName))
 }
 
-func handleSubscriptionApi(sub *Subscription,action string,params map[string]interface{}) *CMQError {
-	result, err := sub.client.cmqCall(action, params)
+func handleSubscriptionApi(sub *Subscription,action string,req interface{}) *CMQError {
+	result, err := sub.client.call(action, req)
 	if err != nil {
 		log.Println("create queue error msg: " + err.Error())
 		return err
@@ -1,11 +1,12 @@
 package cmq
 
 import (
+	"context"
 	"github.com/pkg/errors"
 	"log"
 	"encoding/json"
 	"fmt"
-	"strconv"
+	"github.com/zyw/cmq-goclient/matcher"
 )
 
 const (
@@ -20,6 +21,15 @@ type Topic struct {
 	client *Client
 }
 
+//返回使用ctx发起调用的Topic
+//ctx用于取消请求，并传递给拦截器（比如链路追踪信息）
+func (t *Topic) WithContext(ctx context.Context) *Topic {
+	return &Topic{
+		topicName:t.topicName,
+		client:t.client.withContext(ctx),
+	}
+}
+
 type TopicMeta struct {
 	// 当前该主题的消息堆积数
 	msgCount 				int
@@ -36,45 +46,83 @@ type TopicMeta struct {
 	filterType				int
 }
 
-func (t *Topic) SetTopicAttributes(maxMsgSize int) *CMQError {
-	if maxMsgSize < 1024 || maxMsgSize > 1048576 {
-		return NewCMQOpError(CMQError100,errors.New("Invalid parameter maxMsgSize < 1KB or maxMsgSize > 1024KB"),SetTopicAttributes)
-	}
+type topicAttributes struct {
+	Code int					`json:"code"`
+	Message string				`json:"message"`
+	RequestId string			`json:"requestId"`
+	MsgCount int				`json:"msgCount"`
+	MaxMsgSize int				`json:"maxMsgSize"`
+	MsgRetentionSeconds int		`json:"msgRetentionSeconds"`
+	CreateTime int				`json:"createTime"`
+	LastModifyTime int			`json:"lastModifyTime"`
+	LoggingEnabled int			`json:"loggingEnabled"`
+	FilterType int				`json:"filterType"`
+}
+
+//当前该主题的消息堆积数
+func (meta *TopicMeta) MsgCount() int {
+	return meta.msgCount
+}
+
+func (meta *TopicMeta) MaxMsgSize() int {
+	return meta.maxMsgSize
+}
 
-	params := map[string]interface{} {
-		"topicName":t.topicName,
-		"maxMsgSize":maxMsgSize,
+func (meta *TopicMeta) MsgRetentionSeconds() int {
+	return meta.msgRetentionSeconds
+}
+
+func (meta *TopicMeta) CreateTime() int {
+	return meta.createTime
+}
+
+func (meta *TopicMeta) LastModifyTime() int {
+	return meta.lastModifyTime
+}
+
+//主题的消息匹配策略，1 表示使用 filterTag 标签过滤，2 表示使用 bindingKey 过滤
+func (meta *TopicMeta) FilterType() int {
+	return meta.filterType
+}
+
+func (t *Topic) SetTopicAttributes(maxMsgSize int) *CMQError {
+	if err := firstInvalid(validateName("topicName",t.topicName),validateRange("maxMsgSize",maxMsgSize,1024,DefaultMaxMsgSize));err != nil {
+		return NewCMQOpError(CMQError100,err,SetTopicAttributes)
 	}
 
-	return handleTopicApi(t,SetTopicAttributes,params)
+	return handleTopicApi(t,SetTopicAttributes,&topicAttributesRequest{
+		TopicName:t.topicName,
+		MaxMsgSize:maxMsgSize,
+	})
 }
 
 func (t *Topic) GetTopicAttributes() (*TopicMeta,*CMQError) {
-	params := map[string]interface{} {
-		"topicName":t.topicName,
+	if err := validateName("topicName",t.topicName);err != nil {
+		return nil,NewCMQOpError(CMQError100,err,GetTopicAttributes)
 	}
-	result, err := t.client.cmqCall(GetTopicAttributes, params)
+	result, err := t.client.call(GetTopicAttributes, &topicRequest{TopicName:t.topicName})
 	if err != nil {
 		return nil,err
 	}
-	var res map[string]interface{}
+	var res topicAttributes
 	if err := json.Unmarshal([]byte(result),&res);err != nil {
 		log.Println("parse json string error, msg: " + err.Error())
 		return nil,NewCMQOpError(CMQError102,jsonUnmarshal,GetTopicAttributes)
 	}
-	code := res["code"].(int)
+	code := res.Code
 	if code != 0 {
-		log.Println(fmt.Sprintf("code:%d, %v, RequestId: %v",code,res["message"],res["requestId"]))
-		return nil,NewCMQOpError(erron(code),errors.New(res["message"].(string)),GetTopicAttributes)
+		log.Println(fmt.Sprintf("code:%d, %v, RequestId: %v",code,res.Message,res.RequestId))
+		return nil,NewCMQOpError(erron(code),errors.New(res.Message),GetTopicAttributes)
 	}
 
 	return &TopicMeta{
-		msgCount:res["msgCount"].(int),
-		maxMsgSize:res["maxMsgSize"].(int),
-		msgRetentionSeconds:res["msgRetentionSeconds"].(int),
-		createTime:res["createTime"].(int),
-		lastModifyTime:res["lastModifyTime"].(int),
-		filterType:res["filterType"].(int),
+		msgCount:res.MsgCount,
+		maxMsgSize:res.MaxMsgSize,
+		msgRetentionSeconds:res.MsgRetentionSeconds,
+		createTime:res.CreateTime,
+		lastModifyTime:res.LastModifyTime,
+		loggingEnabled:res.LoggingEnabled,
+		filterType:res.FilterType,
 	},nil
 }
 
@@ -88,21 +136,26 @@ func (t *Topic) GetTopicAttributes() (*TopicMeta,*CMQError) {
 //routingKey 长度<=64字节，该字段用于表示发送消息的路由路径，最多含有 15 个“.”，即最多 16 个词组。
 //消息发送到 topic 类型的 exchange 上时不能随意指定 routingKey。需要符合上面的格式要求，一个由订阅者指定的带有 routingKey 的消息将会推送给所有 BindingKey 能与之匹配的消费者，这种匹配情况有两种关系：
 //1 *（星号），可以替代一个单词（一串连续的字母串）；
-//2 #（井号）：可以匹配一个或多个字符。
+//2 #（井号）：可以匹配零个或多个单词。
 func (t *Topic) PublishMessage(message string, vTagList []string,routingKey string) (string,*CMQError) {
-	params := map[string]interface{} {
-		"topicName": t.topicName,
-		"msgBody": message,
+	message,eerr := t.client.account.encryptMsgBody(message)
+	if eerr != nil {
+		return "",NewCMQOpError(CMQError100,eerr,PublishMessage)
 	}
-
-	if len(routingKey) > 0 {
-		params["routingKey"] = routingKey
+	if err := firstInvalid(
+		validateName("topicName",t.topicName),
+		validateMsgBody("msgBody",message),
+		validateTags("msgTag",vTagList),
+		validateRoutingKey("routingKey",routingKey));err != nil {
+		return "",NewCMQOpError(CMQError100,err,PublishMessage)
 	}
 
-	for i,tl := range vTagList {
-		params["msgTag."+strconv.Itoa(i+1)] = tl
-	}
-	result, err := t.client.cmqCall(PublishMessage, params)
+	result, err := t.client.call(PublishMessage, &publishMessageRequest{
+		TopicName:t.topicName,
+		MsgBody:message,
+		RoutingKey:routingKey,
+		MsgTag:vTagList,
+	})
 	if err != nil {
 		log.Println("create queue error msg: " + err.Error())
 		return "",err
@@ -122,25 +175,30 @@ func (t *Topic) PublishMessage(message string, vTagList []string,routingKey stri
 	return m.MsgId,nil
 }
 
-func (t *Topic) BatchPublishMessage(vMsgList,vTagList []string,routingKey string) ([]string,*CMQError){
-
-	params := map[string]interface{} {
-		"topicName":t.topicName,
-	}
-
-	if len(routingKey) !=0 {
-		params["routingKey"] = routingKey
-	}
+//发布带消息头的消息，消息头和消息正文包装成信封（Envelope）放在 msgBody 中，headers 为空时和 PublishMessage 相同
+func (t *Topic) PublishMessageWithHeaders(message string,headers map[string]string,vTagList []string,routingKey string) (string,*CMQError) {
+	return t.PublishMessage(WrapBody(message,headers),vTagList,routingKey)
+}
 
-	for i,msg := range vTagList {
-		params["msgBody." + strconv.Itoa(i+1)] = msg
+func (t *Topic) BatchPublishMessage(vMsgList,vTagList []string,routingKey string) ([]string,*CMQError){
+	vMsgList,eerr := t.client.account.encryptMsgBodies(vMsgList)
+	if eerr != nil {
+		return nil,NewCMQOpError(CMQError100,eerr,BatchPublishMessage)
 	}
-
-	for i,tl := range vTagList {
-		params["msgTag."+strconv.Itoa(i+1)] = tl
+	if err := firstInvalid(
+		validateName("topicName",t.topicName),
+		validateBatchBodies("msgBody",vMsgList),
+		validateTags("msgTag",vTagList),
+		validateRoutingKey("routingKey",routingKey));err != nil {
+		return nil,NewCMQOpError(CMQError100,err,BatchPublishMessage)
 	}
 
-	result, err := t.client.cmqCall(BatchPublishMessage, params)
+	result, err := t.client.call(BatchPublishMessage, &batchPublishMessageRequest{
+		TopicName:t.topicName,
+		RoutingKey:routingKey,
+		MsgBody:vMsgList,
+		MsgTag:vTagList,
+	})
 
 	if err != nil {
 		log.Println("create queue error msg: " + err.Error())
@@ -166,8 +224,8 @@ func (t *Topic) BatchPublishMessage(vMsgList,vTagList []string,routingKey string
 	return list,nil
 }
 
-func handleTopicApi(topic *Topic,action string,params map[string]interface{}) *CMQError {
-	result, err := topic.client.cmqCall(action, params)
+func handleTopicApi(topic *Topic,action string,req interface{}) *CMQError {
+	result, err := topic.client.call(action, req)
 	if err != nil {
 		log.Println("create queue error msg: " + err.Error())
 		return err
@@ -184,4 +242,42 @@ func handleTopicApi(topic *Topic,action string,params map[string]interface{}) *C
 		return NewCMQOpError(erron(message.Code),errors.New(message.Message),action)
 	}
 	return nil
-}
\ No newline at end of file
+}
+//预测发布到主题的消息会被推送给哪些订阅，返回订阅名称
+//根据主题的 filterType 选择 filterTag 标签过滤或 bindingKey 路由匹配，规则见 matcher 包
+//msgTag、routingKey 与 PublishMessage 的参数相同
+func (t *Topic) MatchSubscriptions(msgTag []string,routingKey string) ([]string,*CMQError) {
+	meta, err := t.GetTopicAttributes()
+	if err != nil {
+		return nil,err
+	}
+
+	var subs []matcher.Subscription
+	for offset := 0;;offset += 100 {
+		sr, err := listSubscriptions(t.client,t.topicName,offset,100,"")
+		if err != nil {
+			return nil,err
+		}
+		for _,sl := range sr.SubscriptionList {
+			sub := &Subscription{
+				topicName:t.topicName,
+				subscriptionName:sl.SubscriptionName,
+				client:t.client,
+			}
+			sm, err := sub.GetSubscriptionAttributes()
+			if err != nil {
+				return nil,err
+			}
+			subs = append(subs,matcher.Subscription{
+				Name:sl.SubscriptionName,
+				FilterTags:sm.FilterTag,
+				BindingKeys:sm.BindingKey,
+			})
+		}
+		if len(sr.SubscriptionList) == 0 || offset + len(sr.SubscriptionList) >= sr.TotalCount {
+			break
+		}
+	}
+
+	return matcher.Match(meta.FilterType(),subs,matcher.Message{Tags:msgTag,RoutingKey:routingKey}),nil
+}
@@ -0,0 +1,283 @@
+package cmq
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// 队列、主题、订阅名称最大长度
+	MaxNameLength = 64
+	// 消息标签（filterTag/msgTag）最大数量
+	MaxTagNum = 5
+	// 单个消息标签最大长度
+	MaxTagLength = 16
+	// routingKey/bindingKey 最大长度，单位字节
+	MaxRoutingKeyLength = 64
+	// routingKey/bindingKey 最多包含的“.”数量
+	MaxRoutingKeyDots = 15
+	// bindingKey 最大数量
+	MaxBindingKeyNum = 5
+	// 批量操作单次最多消息数
+	MaxBatchSize = 16
+	// 批量发送时所有消息正文总长度上限，单位字节
+	MaxBatchBodySize = 65536
+	// 长轮询等待时间上限，单位秒
+	MaxPollingWaitSeconds = 30
+	// 队列消息最大延时，单位秒
+	MaxDelaySeconds = 3600
+)
+
+//参数校验错误
+//在发起网络请求之前发现参数不合法时返回，作为CMQError的Err
+type ValidationError struct {
+	//参数名
+	Field string
+	//参数值
+	Value interface{}
+	//不合法的原因
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid parameter %s: %s", e.Field, e.Reason)
+}
+
+func invalid(field string, value interface{}, format string, a ...interface{}) *ValidationError {
+	return &ValidationError{
+		Field:  field,
+		Value:  value,
+		Reason: fmt.Sprintf(format, a...),
+	}
+}
+
+// 返回第一个不为nil的校验错误
+func firstInvalid(errs ...*ValidationError) *ValidationError {
+	for _, e := range errs {
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// 校验队列、主题、订阅名称
+// 不超过 64 个字符，必须以字母为首字符，剩余部分可以包含字母、数字和横划线(-)
+func validateName(field, name string) *ValidationError {
+	if len(name) == 0 {
+		return invalid(field, name, "is empty")
+	}
+	if len(name) > MaxNameLength {
+		return invalid(field, name, "length %d > %d", len(name), MaxNameLength)
+	}
+	for i, c := range name {
+		switch {
+		case isLetter(c):
+		case i > 0 && (isDigit(c) || c == '-'):
+		case i == 0:
+			return invalid(field, name, "must start with a letter")
+		default:
+			return invalid(field, name, "contains invalid character %q at %d", c, i)
+		}
+	}
+	return nil
+}
+
+// 校验消息标签（filterTag/msgTag），标签数量不能超过5个，每个标签不超过16个字符
+func validateTags(field string, tags []string) *ValidationError {
+	if len(tags) > MaxTagNum {
+		return invalid(field, tags, "tag number %d > %d", len(tags), MaxTagNum)
+	}
+	for i, t := range tags {
+		if len(t) == 0 {
+			return invalid(fmt.Sprintf("%s.%d", field, i+1), t, "is empty")
+		}
+		if n := len([]rune(t)); n > MaxTagLength {
+			return invalid(fmt.Sprintf("%s.%d", field, i+1), t, "length %d > %d", n, MaxTagLength)
+		}
+	}
+	return nil
+}
+
+// 校验routingKey，长度<=64字节，最多含有 15 个“.”
+// routingKey为空表示不设置
+func validateRoutingKey(field, key string) *ValidationError {
+	if len(key) > MaxRoutingKeyLength {
+		return invalid(field, key, "length %d > %d bytes", len(key), MaxRoutingKeyLength)
+	}
+	if n := strings.Count(key, "."); n > MaxRoutingKeyDots {
+		return invalid(field, key, "contains %d dots > %d", n, MaxRoutingKeyDots)
+	}
+	return nil
+}
+
+// 校验bindingKey，数量不超过 5 个，每个长度不超过 64 字节，最多含有 15 个“.”
+func validateBindingKeys(field string, keys []string) *ValidationError {
+	if len(keys) > MaxBindingKeyNum {
+		return invalid(field, keys, "bindingKey number %d > %d", len(keys), MaxBindingKeyNum)
+	}
+	for i, k := range keys {
+		f := fmt.Sprintf("%s.%d", field, i+1)
+		if len(k) == 0 {
+			return invalid(f, k, "is empty")
+		}
+		if err := validateRoutingKey(f, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 校验消息正文，至少 1 Byte，最大长度不超过 DefaultMaxMsgSize
+func validateMsgBody(field, body string) *ValidationError {
+	if len(body) == 0 {
+		return invalid(field, body, "is empty")
+	}
+	if len(body) > DefaultMaxMsgSize {
+		return invalid(field, len(body), "size %d > %d bytes", len(body), DefaultMaxMsgSize)
+	}
+	return nil
+}
+
+// 校验批量操作的数量，1-16
+func validateBatchSize(field string, n int) *ValidationError {
+	if n <= 0 {
+		return invalid(field, n, "is empty")
+	}
+	if n > MaxBatchSize {
+		return invalid(field, n, "batch size %d > %d", n, MaxBatchSize)
+	}
+	return nil
+}
+
+// 校验批量发送的消息正文，数量1-16，正文总长度不超过64K
+func validateBatchBodies(field string, bodies []string) *ValidationError {
+	if err := validateBatchSize(field, len(bodies)); err != nil {
+		return err
+	}
+	total := 0
+	for i, b := range bodies {
+		if len(b) == 0 {
+			return invalid(fmt.Sprintf("%s.%d", field, i), b, "is empty")
+		}
+		total += len(b)
+	}
+	if total > MaxBatchBodySize {
+		return invalid(field, total, "total size %d > %d bytes", total, MaxBatchBodySize)
+	}
+	return nil
+}
+
+// 校验非空字符串
+func validateNotEmpty(field, value string) *ValidationError {
+	if len(value) == 0 {
+		return invalid(field, value, "is empty")
+	}
+	return nil
+}
+
+// 校验取值范围 [min,max]
+func validateRange(field string, value, min, max int) *ValidationError {
+	if value < min || value > max {
+		return invalid(field, value, "%d out of range [%d,%d]", value, min, max)
+	}
+	return nil
+}
+
+// 校验取值是否在可选值中
+func validateOneOf(field, value string, options ...string) *ValidationError {
+	for _, o := range options {
+		if value == o {
+			return nil
+		}
+	}
+	return invalid(field, value, "must be one of %s", strings.Join(options, ","))
+}
+
+// 校验队列属性，只校验设置了的属性（>0）
+// pollingWaitSeconds 0-30 秒，visibilityTimeout 1-43200 秒，maxMsgSize 1024-1048576 Byte，
+// msgRetentionSeconds 60-1296000 秒，rewindSeconds 不能大于 msgRetentionSeconds
+func validateQueueMeta(meta *QueueMeta) *ValidationError {
+	if meta.pollingWaitSeconds > 0 {
+		if err := validateRange("pollingWaitSeconds", meta.pollingWaitSeconds, 0, MaxPollingWaitSeconds); err != nil {
+			return err
+		}
+	}
+	if meta.visibilityTimeout > 0 {
+		if err := validateRange("visibilityTimeout", meta.visibilityTimeout, 1, 43200); err != nil {
+			return err
+		}
+	}
+	if meta.maxMsgSize > 0 {
+		if err := validateRange("maxMsgSize", meta.maxMsgSize, 1024, DefaultMaxMsgSize); err != nil {
+			return err
+		}
+	}
+	if meta.msgRetentionSeconds > 0 {
+		if err := validateRange("msgRetentionSeconds", meta.msgRetentionSeconds, 60, 1296000); err != nil {
+			return err
+		}
+	}
+	if meta.rewindSeconds > 0 && meta.msgRetentionSeconds > 0 {
+		if err := validateRange("rewindSeconds", meta.rewindSeconds, 0, meta.msgRetentionSeconds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 校验长轮询等待时间，0-30 秒，小于0表示使用队列属性中的 pollingWaitSeconds 值
+func validatePollingWaitSeconds(pollingWaitSeconds int) *ValidationError {
+	if pollingWaitSeconds < 0 {
+		return nil
+	}
+	return validateRange("pollingWaitSeconds", pollingWaitSeconds, 0, MaxPollingWaitSeconds)
+}
+
+// 校验主题的filterType，0（不填）、1 或 2
+func validateFilterType(filterType int) *ValidationError {
+	return validateRange("filterType", filterType, 0, FilterTypeBindingKey)
+}
+
+// 校验订阅的endpoint，对于 http，endpoint 必须以 “http://” 开头；对于 queue，则填 queueName
+func validateEndpoint(protocol, endpoint string) *ValidationError {
+	switch protocol {
+	case ProtocolHttp:
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			return invalid("endpoint", endpoint, "must start with http:// when protocol is %s", protocol)
+		}
+		return nil
+	case ProtocolQueue:
+		return validateName("endpoint", endpoint)
+	}
+	return validateNotEmpty("endpoint", endpoint)
+}
+
+// 校验地域，比如 bj、ap-guangzhou，只能包含小写字母、数字和横划线(-)，用于拼接 endpoint 的域名
+func validateRegion(field, region string) *ValidationError {
+	if len(region) == 0 {
+		return invalid(field, region, "is empty")
+	}
+	for _, c := range region {
+		if !(c >= 'a' && c <= 'z') && !isDigit(c) && c != '-' {
+			return invalid(field, region, "contains invalid character %q", c)
+		}
+	}
+	return nil
+}
+
+// 校验推送内容的格式，如果 protocol 是 queue，则取值必须为 SIMPLIFIED
+func validateNotifyContentFormat(protocol, format string) *ValidationError {
+	if protocol == ProtocolQueue {
+		return validateOneOf("notifyContentFormat", format, NotifyContentFormatSimplified)
+	}
+	return validateOneOf("notifyContentFormat", format, NotifyContentFormatDefault, NotifyContentFormatSimplified)
+}
+
+func isLetter(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,114 @@
+package cmq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	cases := map[string]bool{
+		"nsop-cloud-mq":         true,
+		"a":                     true,
+		"A1-b2":                 true,
+		"":                      false,
+		"1queue":                false,
+		"-queue":                false,
+		"queue_name":            false,
+		"queue.name":            false,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+	}
+	for name, ok := range cases {
+		if err := validateName("queueName", name); (err == nil) != ok {
+			t.Errorf("validateName(%q) = %v, want ok=%v", name, err, ok)
+		}
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	if err := validateTags("msgTag", []string{"a", "b", "c", "d", "e"}); err != nil {
+		t.Error(err)
+	}
+	if err := validateTags("msgTag", []string{"a", "b", "c", "d", "e", "f"}); err == nil {
+		t.Error("6 tags should be invalid")
+	}
+	err := validateTags("msgTag", []string{"ok", strings.Repeat("x", 17)})
+	if err == nil || err.Field != "msgTag.2" {
+		t.Errorf("want msgTag.2 error, got %v", err)
+	}
+}
+
+func TestValidateRoutingKey(t *testing.T) {
+	if err := validateRoutingKey("routingKey", "a.b.c"); err != nil {
+		t.Error(err)
+	}
+	if err := validateRoutingKey("routingKey", strings.Repeat("a.", 16)+"a"); err == nil {
+		t.Error("16 dots should be invalid")
+	}
+	if err := validateRoutingKey("routingKey", strings.Repeat("a", 65)); err == nil {
+		t.Error("65 bytes should be invalid")
+	}
+	if err := validateBindingKeys("bindingKey", []string{"a.*", "#", "b", "c", "d", "e"}); err == nil {
+		t.Error("6 binding keys should be invalid")
+	}
+}
+
+func TestValidateBatchBodies(t *testing.T) {
+	if err := validateBatchBodies("msgBody", nil); err == nil {
+		t.Error("empty batch should be invalid")
+	}
+	if err := validateBatchBodies("msgBody", make([]string, 17)); err == nil {
+		t.Error("17 messages should be invalid")
+	}
+	big := strings.Repeat("x", MaxBatchBodySize/2+1)
+	if err := validateBatchBodies("msgBody", []string{big, big}); err == nil {
+		t.Error("batch larger than 64K should be invalid")
+	}
+}
+
+func TestTopic_PublishMessageInvalid(t *testing.T) {
+	account := NewAccountDefault("http://127.0.0.1:1", "", "")
+
+	topic := account.GetTopic("topic-a")
+	_, err := topic.PublishMessage("hello", []string{"a", "b", "c", "d", "e", "f"}, "")
+	if err == nil || err.Code != CMQError100 {
+		t.Fatalf("want CMQError100, got %v", err)
+	}
+	if verr, ok := err.Err.(*ValidationError); !ok || verr.Field != "msgTag" {
+		t.Errorf("want msgTag ValidationError, got %#v", err.Err)
+	}
+}
+
+func TestSubscription_SetAttributesQueueProtocol(t *testing.T) {
+	var calls []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.FormValue("Action")
+		calls = append(calls, action)
+		if action == GetSubscriptionAttributes {
+			w.Write([]byte(`{"code":0,"protocol":"queue","endpoint":"queue-a"}`))
+			return
+		}
+		w.Write([]byte(`{"code":0}`))
+	}))
+	defer s.Close()
+	sub := NewAccountDefault(s.URL, "id", "key").GetSubscription("topic-a", "sub-a")
+
+	// 没有设置 Protocal 时查询订阅的协议
+	err := sub.SetSubscriptionAttributes(SubscriptionMeta{NotifyContentFormat: NotifyContentFormatDefault})
+	if err == nil || err.Code != CMQError100 {
+		t.Fatalf("JSON should be invalid for queue protocol, got %v", err)
+	}
+	if len(calls) != 1 || calls[0] != GetSubscriptionAttributes {
+		t.Errorf("calls = %v", calls)
+	}
+
+	calls = nil
+	if err := sub.SetSubscriptionAttributes(SubscriptionMeta{NotifyContentFormat: NotifyContentFormatSimplified}); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != SetSubscriptionAttributes {
+		t.Errorf("SIMPLIFIED should not fetch the protocol, calls = %v", calls)
+	}
+}
@@ -0,0 +1,69 @@
+// 注册 zstd 和 snappy 压缩算法（纯 Go 实现）
+//
+//	import _ "github.com/zyw/cmq-goclient/cmqcompress"
+//
+//	producer.SetCompression(cmqcompress.Zstd, 4096)
+//
+// 生产者和消费者都需要导入这个包，消费者才能自动解压。
+package cmqcompress
+
+import (
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/zyw/cmq-goclient/cmq"
+)
+
+const (
+	Zstd   = "zstd"
+	Snappy = "snappy"
+)
+
+func init() {
+	cmq.RegisterCompressor(zstdCompressor{})
+	cmq.RegisterCompressor(snappyCompressor{})
+}
+
+// 编码器和解码器可以并发使用，共享一个实例
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(cmq.MaxDecompressedSize))
+)
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string {
+	return Zstd
+}
+
+func (zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	res, err := zstdDecoder.DecodeAll(data, nil)
+	if err == zstd.ErrDecoderSizeExceeded || err == zstd.ErrWindowSizeExceeded {
+		return nil, cmq.ErrDecompressedTooLarge
+	}
+	return res, err
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string {
+	return Snappy
+}
+
+func (snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > cmq.MaxDecompressedSize {
+		return nil, cmq.ErrDecompressedTooLarge
+	}
+	return snappy.Decode(nil, data)
+}
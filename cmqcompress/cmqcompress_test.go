@@ -0,0 +1,60 @@
+package cmqcompress
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zyw/cmq-goclient/cmq"
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestProducer_Compression(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	q := cmq.NewAccountDefault(s.URL, "id", "key").GetQueue("queue-a")
+	body := strings.Repeat("hello cmq ", 500)
+
+	for _, name := range []string{cmq.CompressionGzip, Zstd, Snappy} {
+		p := cmq.NewQueueProducer(q)
+		if err := p.SetCompression(name, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Send(context.Background(), &cmq.ProducerMessage{Body: body}); err != nil {
+			t.Fatal(err)
+		}
+		msgs := s.Messages("queue-a")
+		if sent := msgs[len(msgs)-1].Body; len(sent) >= len(body)/2 {
+			t.Errorf("%s: sent %d bytes, want compressed", name, len(sent))
+		}
+
+		m, err := q.ReceiveMessage(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.DeleteMessage(m.ReceiptHandle)
+		if m.MsgBody != body || m.Headers != nil {
+			t.Errorf("%s: received %d bytes, headers %v", name, len(m.MsgBody), m.Headers)
+		}
+	}
+}
+
+func TestCompressBody_Small(t *testing.T) {
+	body, headers, err := cmq.CompressBody("hi", nil, Zstd)
+	if err != nil || body != "hi" || headers != nil {
+		t.Errorf("CompressBody() = %q, %v, %v, want body untouched", body, headers, err)
+	}
+}
+
+func TestDecompressBody_TooLarge(t *testing.T) {
+	body := strings.Repeat("x", cmq.MaxDecompressedSize+1)
+	for _, name := range []string{cmq.CompressionGzip, Zstd, Snappy} {
+		compressed, headers, err := cmq.CompressBody(body, nil, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := cmq.DecompressBody(compressed, headers); err != cmq.ErrDecompressedTooLarge {
+			t.Errorf("%s: DecompressBody() error = %v, want ErrDecompressedTooLarge", name, err)
+		}
+	}
+}
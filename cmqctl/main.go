@@ -0,0 +1,154 @@
+// CMQ 命令行工具
+//
+//	cmqctl match -endpoint https://cmq-topic-bj.api.qcloud.com -topic order -tags a,b -routing-key order.created
+//	cmqctl watch -region bj -queues order,payment -topics order -interval 10s
+//
+// secretId、secretKey、region 默认从环境变量 CMQ_SECRET_ID、CMQ_SECRET_KEY、CMQ_REGION 读取
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmq"
+)
+
+var commands = map[string]func(args []string) error{
+	"match": match,
+	"watch": watch,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		usage()
+		os.Exit(2)
+	}
+	if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "错误：", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "用法：cmqctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "  match  预测发布到主题的消息会被推送给哪些订阅")
+	fmt.Fprintln(os.Stderr, "  watch  定时输出队列、主题、订阅的消息积压情况")
+}
+
+// 帐号相关的公共参数
+type accountFlags struct {
+	endpoint  *string
+	region    *string
+	network   *string
+	secretId  *string
+	secretKey *string
+}
+
+func newAccountFlags(fs *flag.FlagSet) *accountFlags {
+	return &accountFlags{
+		endpoint:  fs.String("endpoint", "", "CMQ endpoint，例如 https://cmq-topic-bj.api.qcloud.com，和 -region 二选一"),
+		region:    fs.String("region", os.Getenv("CMQ_REGION"), "地域，例如 bj、gz，按地域解析队列和主题的 endpoint"),
+		network:   fs.String("network", cmq.NetworkPublic, "网络类型，public 或 internal"),
+		secretId:  fs.String("secret-id", os.Getenv("CMQ_SECRET_ID"), "secretId"),
+		secretKey: fs.String("secret-key", os.Getenv("CMQ_SECRET_KEY"), "secretKey"),
+	}
+}
+
+func (f *accountFlags) account() (*cmq.CmqConfig, error) {
+	if len(*f.endpoint) != 0 {
+		return cmq.NewAccountDefault(*f.endpoint, *f.secretId, *f.secretKey), nil
+	}
+	if len(*f.region) == 0 {
+		return nil, fmt.Errorf("-endpoint or -region is required")
+	}
+	account, err := cmq.NewAccountRegion(*f.region, *f.network, *f.secretId, *f.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func match(args []string) error {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	af := newAccountFlags(fs)
+	topic := fs.String("topic", "", "主题名称")
+	tags := fs.String("tags", "", "消息标签，多个用逗号分隔")
+	routingKey := fs.String("routing-key", "", "消息的 routingKey")
+	fs.Parse(args)
+
+	account, err := af.account()
+	if err != nil {
+		return err
+	}
+	var msgTag []string
+	if len(*tags) != 0 {
+		msgTag = strings.Split(*tags, ",")
+	}
+
+	names, cerr := account.GetTopic(*topic).MatchSubscriptions(msgTag, *routingKey)
+	if cerr != nil {
+		return cerr
+	}
+	for _, n := range names {
+		fmt.Println(n)
+	}
+	return nil
+}
+
+func watch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	af := newAccountFlags(fs)
+	queues := fs.String("queues", "", "队列名称，多个用逗号分隔")
+	all := fs.Bool("all", false, "监控帐号下的所有队列")
+	topics := fs.String("topics", "", "主题名称，多个用逗号分隔")
+	subscriptions := fs.String("subscriptions", "", "订阅，格式为 主题名/订阅名，多个用逗号分隔")
+	interval := fs.Duration("interval", 10*time.Second, "采集间隔")
+	fs.Parse(args)
+
+	if *interval <= 0 {
+		return fmt.Errorf("invalid -interval %s, must be positive", *interval)
+	}
+	account, err := af.account()
+	if err != nil {
+		return err
+	}
+	m := account.NewMonitor(*interval)
+	if len(*queues) != 0 {
+		m.AddQueue(strings.Split(*queues, ",")...)
+	}
+	if *all {
+		m.AddAllQueues()
+	}
+	if len(*topics) != 0 {
+		m.AddTopic(strings.Split(*topics, ",")...)
+	}
+	if len(*subscriptions) != 0 {
+		for _, s := range strings.Split(*subscriptions, ",") {
+			parts := strings.SplitN(s, "/", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid subscription %q, want topic/subscription", s)
+			}
+			m.AddSubscription(parts[0], parts[1])
+		}
+	}
+
+	m.OnPoll(func(res []*cmq.Backlog) {
+		fmt.Printf("%-13s %-40s %8s %8s %8s %10s %10s\n", "KIND", "NAME", "ACTIVE", "INACTIVE", "DELAY", "AGE", "RATE/S")
+		for _, b := range res {
+			fmt.Printf("%-13s %-40s %8d %8d %8d %10s %10.2f\n", b.Kind, b.Name, b.ActiveMsgNum, b.InactiveMsgNum, b.DelayMsgNum, b.Age.Truncate(time.Second), b.GrowthRate)
+		}
+		fmt.Println()
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := m.Run(ctx); err != context.Canceled {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// cmq.Deduplicator 的持久化幂等键存储
+//
+// SQLStore 把幂等键保存在数据库表中，多个进程的消费者共用一张表去重。
+package cmqidem
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmq"
+)
+
+// 缺省的幂等键表名
+const DefaultTable = "cmq_idempotency"
+
+// SQL 方言，决定参数占位符
+type Dialect int
+
+const (
+	//SQLite，使用 ? 作为占位符
+	DialectSQLite Dialect = iota
+	//PostgreSQL，使用 $1、$2 作为占位符
+	DialectPostgres
+	//MySQL，使用 ? 作为占位符
+	DialectMySQL
+)
+
+// 保存在数据库中的幂等键，实现了 cmq.IdempotencyStore，可以在多个进程的消费者之间去重
+//
+//	store := cmqidem.NewSQLStore(db)
+//	store.CreateTable(ctx)
+//	consumer.Use(cmq.NewDeduplicator(store, time.Hour).Middleware())
+type SQLStore struct {
+	db      *sql.DB
+	table   string
+	dialect Dialect
+	now     func() time.Time
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{
+		db:      db,
+		table:   DefaultTable,
+		dialect: DialectSQLite,
+		now:     time.Now,
+	}
+}
+
+// 设置表名
+func (s *SQLStore) SetTable(table string) {
+	s.table = table
+}
+
+// 设置 SQL 方言，默认为 DialectSQLite
+func (s *SQLStore) SetDialect(d Dialect) {
+	s.dialect = d
+}
+
+// 创建幂等键表（如果不存在）
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+	idem_key VARCHAR(255) NOT NULL PRIMARY KEY,
+	done INTEGER NOT NULL,
+	expire BIGINT NOT NULL
+)`)
+	return err
+}
+
+func (s *SQLStore) Claim(ctx context.Context, key string, lease time.Duration) (cmq.ClaimResult, error) {
+	now := s.now()
+	expire := now.Add(lease).UnixNano()
+	// 接管已经过期的键
+	res, err := s.db.ExecContext(ctx, rebind(s.dialect, `UPDATE `+s.table+
+		` SET done = 0, expire = ? WHERE idem_key = ? AND expire <= ?`), expire, key, now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return cmq.ClaimAcquired, nil
+	}
+	_, err = s.db.ExecContext(ctx, rebind(s.dialect, `INSERT INTO `+s.table+
+		` (idem_key, done, expire) VALUES (?, 0, ?)`), key, expire)
+	if err == nil {
+		return cmq.ClaimAcquired, nil
+	}
+	// 主键冲突，键已经存在
+	var done int
+	if qerr := s.db.QueryRowContext(ctx, rebind(s.dialect, `SELECT done FROM `+s.table+
+		` WHERE idem_key = ?`), key).Scan(&done); qerr != nil {
+		return 0, err
+	}
+	if done != 0 {
+		return cmq.ClaimCompleted, nil
+	}
+	return cmq.ClaimInProgress, nil
+}
+
+func (s *SQLStore) Complete(ctx context.Context, key string, window time.Duration) error {
+	expire := s.now().Add(window).UnixNano()
+	res, err := s.db.ExecContext(ctx, rebind(s.dialect, `UPDATE `+s.table+
+		` SET done = 1, expire = ? WHERE idem_key = ?`), expire, key)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+	_, err = s.db.ExecContext(ctx, rebind(s.dialect, `INSERT INTO `+s.table+
+		` (idem_key, done, expire) VALUES (?, 1, ?)`), key, expire)
+	return err
+}
+
+func (s *SQLStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, rebind(s.dialect, `DELETE FROM `+s.table+` WHERE idem_key = ?`), key)
+	return err
+}
+
+// 删除已经过期的键，返回删除的键数
+func (s *SQLStore) Purge(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, rebind(s.dialect, `DELETE FROM `+s.table+` WHERE expire <= ?`), s.now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// 把 ? 占位符转换为方言使用的占位符
+func rebind(d Dialect, query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
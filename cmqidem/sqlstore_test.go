@@ -0,0 +1,50 @@
+package cmqidem
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/zyw/cmq-goclient/cmq"
+)
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "idempotency.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	now := time.Now()
+	s := NewSQLStore(db)
+	s.now = func() time.Time { return now }
+	if err := s.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	claim := func(want cmq.ClaimResult) {
+		t.Helper()
+		if res, err := s.Claim(ctx, "msg-1", time.Minute); err != nil || res != want {
+			t.Errorf("Claim() = %v, %v, want %v", res, err, want)
+		}
+	}
+	claim(cmq.ClaimAcquired)
+	claim(cmq.ClaimInProgress)
+	if err := s.Release(ctx, "msg-1"); err != nil {
+		t.Fatal(err)
+	}
+	claim(cmq.ClaimAcquired)
+	if err := s.Complete(ctx, "msg-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	claim(cmq.ClaimCompleted)
+
+	now = now.Add(2 * time.Hour)
+	if n, err := s.Purge(ctx); err != nil || n != 1 {
+		t.Errorf("Purge() = %d, %v", n, err)
+	}
+	claim(cmq.ClaimAcquired)
+}
@@ -0,0 +1,192 @@
+// cmq.Metrics 的 Prometheus 实现
+//
+//	m := cmqprom.NewMetrics("myapp")
+//	prometheus.MustRegister(m)
+//	account.SetMetrics(m)
+//	consumer.SetMetrics(m)
+//	monitor.SetMetrics(m)
+package cmqprom
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zyw/cmq-goclient/cmq"
+)
+
+// 实现了 cmq.Metrics、cmq.BacklogMetrics、cmq.SpoolMetrics 和 prometheus.Collector
+type Metrics struct {
+	calls    *prometheus.HistogramVec
+	retries  *prometheus.CounterVec
+	sent     *prometheus.CounterVec
+	received *prometheus.CounterVec
+	deleted  *prometheus.CounterVec
+	handle   *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+	backlog  *prometheus.GaugeVec
+	age      *prometheus.GaugeVec
+	growth   *prometheus.GaugeVec
+	spool    *prometheus.GaugeVec
+	spoolLen *prometheus.GaugeVec
+	dropped  *prometheus.CounterVec
+}
+
+// 创建指标，namespace 为指标名前缀，可以为空
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		calls: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "call_duration_seconds",
+			Help:      "CMQ API 调用耗时，code 为 0 表示成功",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"action", "resource", "code"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "call_retries_total",
+			Help:      "CMQ API 调用重试次数",
+		}, []string{"action", "resource"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "messages_sent_total",
+			Help:      "发送成功的消息数",
+		}, []string{"resource"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "messages_received_total",
+			Help:      "接收到的消息数",
+		}, []string{"resource"}),
+		deleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "messages_deleted_total",
+			Help:      "删除成功的消息数",
+		}, []string{"resource"}),
+		handle: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "handle_duration_seconds",
+			Help:      "消息处理耗时，result 为 success 或 error",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"resource", "result"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "messages_in_flight",
+			Help:      "正在处理的消息数",
+		}, []string{"resource"}),
+		backlog: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "backlog_messages",
+			Help:      "积压的消息数，state 为 active、inactive 或 delay",
+		}, []string{"kind", "resource", "state"}),
+		age: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "backlog_age_seconds",
+			Help:      "最早的未消费消息已经等待的时间",
+		}, []string{"kind", "resource"}),
+		growth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "backlog_growth_rate",
+			Help:      "积压消息数每秒的增长量",
+		}, []string{"kind", "resource"}),
+		spool: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "spool_messages",
+			Help:      "本地 spool 中待转发的消息数",
+		}, []string{"resource"}),
+		spoolLen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "spool_bytes",
+			Help:      "本地 spool 文件的字节数",
+		}, []string{"resource"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cmq",
+			Name:      "spool_dropped_total",
+			Help:      "超过最长保留时间或被服务端拒绝而丢弃的 spool 消息数",
+		}, []string{"resource"}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.calls, m.retries, m.sent, m.received, m.deleted, m.handle, m.inFlight, m.backlog, m.age, m.growth, m.spool, m.spoolLen, m.dropped}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) ObserveCall(action, resource string, code int, d time.Duration) {
+	m.calls.WithLabelValues(action, resource, strconv.Itoa(code)).Observe(d.Seconds())
+}
+
+func (m *Metrics) IncRetry(action, resource string) {
+	m.retries.WithLabelValues(action, resource).Inc()
+}
+
+func (m *Metrics) AddSent(resource string, n int) {
+	m.sent.WithLabelValues(resource).Add(float64(n))
+}
+
+func (m *Metrics) AddReceived(resource string, n int) {
+	m.received.WithLabelValues(resource).Add(float64(n))
+}
+
+func (m *Metrics) AddDeleted(resource string, n int) {
+	m.deleted.WithLabelValues(resource).Add(float64(n))
+}
+
+func (m *Metrics) ObserveHandle(resource string, err error, d time.Duration) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.handle.WithLabelValues(resource, result).Observe(d.Seconds())
+}
+
+func (m *Metrics) AddInFlight(resource string, delta int) {
+	m.inFlight.WithLabelValues(resource).Add(float64(delta))
+}
+
+func (m *Metrics) SetBacklog(b *cmq.Backlog) {
+	m.backlog.WithLabelValues(b.Kind, b.Name, "active").Set(float64(b.ActiveMsgNum))
+	m.backlog.WithLabelValues(b.Kind, b.Name, "inactive").Set(float64(b.InactiveMsgNum))
+	m.backlog.WithLabelValues(b.Kind, b.Name, "delay").Set(float64(b.DelayMsgNum))
+	m.age.WithLabelValues(b.Kind, b.Name).Set(b.Age.Seconds())
+	m.growth.WithLabelValues(b.Kind, b.Name).Set(b.GrowthRate)
+}
+
+func (m *Metrics) DeleteBacklog(kind, name string) {
+	for _, state := range []string{"active", "inactive", "delay"} {
+		m.backlog.DeleteLabelValues(kind, name, state)
+	}
+	m.age.DeleteLabelValues(kind, name)
+	m.growth.DeleteLabelValues(kind, name)
+}
+
+func (m *Metrics) SetSpoolDepth(resource string, messages int, bytes int64) {
+	m.spool.WithLabelValues(resource).Set(float64(messages))
+	m.spoolLen.WithLabelValues(resource).Set(float64(bytes))
+}
+
+func (m *Metrics) AddSpoolDropped(resource string, n int) {
+	m.dropped.WithLabelValues(resource).Add(float64(n))
+}
@@ -0,0 +1,56 @@
+package cmqprom
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/zyw/cmq-goclient/cmq"
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func TestMetrics(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+
+	m := NewMetrics("test")
+	account := cmq.NewAccountDefault(s.URL, "id", "key")
+	account.SetMetrics(m)
+	producer := cmq.NewQueueProducer(account.GetQueue("queue-a"))
+	producer.SetMetrics(m)
+
+	if _, err := producer.Send(context.Background(), &cmq.ProducerMessage{Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	s.FailNext(cmq.SendMessage, 6000)
+	if _, err := producer.Send(context.Background(), &cmq.ProducerMessage{Body: "hello"}); err == nil {
+		t.Fatal("want injected error")
+	}
+
+	if n := testutil.ToFloat64(m.sent.WithLabelValues("queue-a")); n != 1 {
+		t.Errorf("sent = %v", n)
+	}
+	if n := testutil.CollectAndCount(m.calls); n != 2 {
+		t.Errorf("want 2 call series (code 0 and 6000), got %d", n)
+	}
+}
+
+func TestMetrics_SetBacklog(t *testing.T) {
+	m := NewMetrics("test")
+	m.SetBacklog(&cmq.Backlog{Kind: cmq.ResourceQueue, Name: "queue-a", ActiveMsgNum: 3, DelayMsgNum: 2, GrowthRate: 0.5})
+
+	if n := testutil.ToFloat64(m.backlog.WithLabelValues(cmq.ResourceQueue, "queue-a", "active")); n != 3 {
+		t.Errorf("active = %v", n)
+	}
+	if n := testutil.ToFloat64(m.growth.WithLabelValues(cmq.ResourceQueue, "queue-a")); n != 0.5 {
+		t.Errorf("growth = %v", n)
+	}
+
+	m.DeleteBacklog(cmq.ResourceQueue, "queue-a")
+	for _, c := range []prometheus.Collector{m.backlog, m.age, m.growth} {
+		if n := testutil.CollectAndCount(c); n != 0 {
+			t.Errorf("deleted queue still has %d series", n)
+		}
+	}
+}
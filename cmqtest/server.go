@@ -0,0 +1,356 @@
+// 用于测试的内存版 CMQ 服务
+//
+//	s := cmqtest.NewServer()
+//	defer s.Close()
+//	account := cmq.NewAccountDefault(s.URL, "id", "key")
+//
+// 支持队列的发送、接收、删除消息，查询队列属性和列表，以及主题的发布消息
+// 默认不校验签名，调用 RequireSignature 后校验
+package cmqtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zyw/cmq-goclient/util"
+)
+
+// 没有消息时 ReceiveMessage 的等待时间
+const emptyReceiveDelay = 20 * time.Millisecond
+
+// 队列中的一条消息
+type Message struct {
+	MsgId        string
+	Body         string
+	Tags         []string
+	RoutingKey   string
+	DequeueCount int
+	EnqueueTime  time.Time
+	visibleAt    time.Time
+	handle       string
+}
+
+// 内存版 CMQ 服务
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	seq       int
+	queues    map[string][]*Message
+	published map[string][]*Message
+	calls     []string
+	failures  map[string][]int
+	verifier  *util.Verifier
+	offset    time.Duration
+	// 接收后消息不可见的时间，默认 30 秒
+	VisibilityTimeout time.Duration
+}
+
+// 启动服务
+func NewServer() *Server {
+	s := &Server{
+		queues:            map[string][]*Message{},
+		published:         map[string][]*Message{},
+		failures:          map[string][]int{},
+		VisibilityTimeout: 30 * time.Second,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// 校验请求签名，secrets 为 secretId 到 secretKey 的映射，校验失败返回错误码 4100
+func (s *Server) RequireSignature(secrets map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verifier = util.NewVerifier(func(secretId string) (string, bool) {
+		key, ok := secrets[secretId]
+		return key, ok
+	})
+	s.verifier.SetClock(s.now)
+}
+
+// 模拟服务端时钟比真实时间快 offset，影响响应的 Date 头和签名的时间校验
+func (s *Server) SetClockOffset(offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = offset
+}
+
+func (s *Server) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Add(s.offset)
+}
+
+// 让接下来的一次 action 调用返回错误码 code
+func (s *Server) FailNext(action string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[action] = append(s.failures[action], code)
+}
+
+// 按顺序返回收到的所有 action
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// 队列中还没有删除的消息
+func (s *Server) Messages(queueName string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []Message
+	for _, m := range s.queues[queueName] {
+		msgs = append(msgs, *m)
+	}
+	return msgs
+}
+
+// 发布到主题的所有消息
+func (s *Server) Published(topicName string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []Message
+	for _, m := range s.published[topicName] {
+		msgs = append(msgs, *m)
+	}
+	return msgs
+}
+
+// 直接向队列中放入一条消息，返回消息Id
+func (s *Server) Enqueue(queueName, body string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enqueue(queueName, body, 0).MsgId
+}
+
+func (s *Server) enqueue(queueName, body string, delay int) *Message {
+	s.seq++
+	now := time.Now()
+	m := &Message{
+		MsgId:       strconv.Itoa(s.seq),
+		Body:        body,
+		EnqueueTime: now,
+		visibleAt:   now.Add(time.Duration(delay) * time.Second),
+	}
+	s.queues[queueName] = append(s.queues[queueName], m)
+	return m
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	w.Header().Set("Date", s.now().UTC().Format(http.TimeFormat))
+	s.mu.Lock()
+	verifier := s.verifier
+	s.mu.Unlock()
+	if verifier != nil {
+		if err := verifier.VerifyRequest(r); err != nil {
+			reply(w, map[string]interface{}{"code": 4100, "message": "authentication failed: " + err.Error()})
+			return
+		}
+	}
+	s.mu.Lock()
+	res, empty := s.handle(r.Form)
+	s.mu.Unlock()
+	if empty {
+		// 模拟长轮询，避免消费者空转
+		time.Sleep(emptyReceiveDelay)
+	}
+	reply(w, res)
+}
+
+// 处理一次调用，返回响应内容；没有可接收的消息时 empty 为 true
+func (s *Server) handle(p url.Values) (res map[string]interface{}, empty bool) {
+	action := p.Get("Action")
+	s.calls = append(s.calls, action)
+
+	if codes := s.failures[action]; len(codes) > 0 {
+		s.failures[action] = codes[1:]
+		return map[string]interface{}{"code": codes[0], "message": "injected failure"}, false
+	}
+
+	switch action {
+	case "SendMessage":
+		m := s.enqueue(p.Get("queueName"), p.Get("msgBody"), atoi(p.Get("delaySeconds")))
+		return map[string]interface{}{"msgId": m.MsgId}, false
+	case "BatchSendMessage":
+		var list []map[string]string
+		for _, body := range indexed(p, "msgBody") {
+			m := s.enqueue(p.Get("queueName"), body, atoi(p.Get("delaySeconds")))
+			list = append(list, map[string]string{"msgId": m.MsgId})
+		}
+		return map[string]interface{}{"msgList": list}, false
+	case "ReceiveMessage", "BatchReceiveMessage":
+		n := 1
+		if action == "BatchReceiveMessage" {
+			n = atoi(p.Get("numOfMsg"))
+		}
+		msgs := s.receive(p.Get("queueName"), n)
+		if len(msgs) == 0 {
+			return map[string]interface{}{"code": 7000, "message": "no message"}, true
+		}
+		if action == "ReceiveMessage" {
+			return msgs[0], false
+		}
+		return map[string]interface{}{"msgInfoList": msgs}, false
+	case "DeleteMessage":
+		s.delete(p.Get("queueName"), p.Get("receiptHandle"))
+		return nil, false
+	case "BatchDeleteMessage":
+		for _, h := range indexed(p, "receiptHandle") {
+			s.delete(p.Get("queueName"), h)
+		}
+		return nil, false
+	case "GetQueueAttributes":
+		return s.queueAttributes(p.Get("queueName")), false
+	case "DeleteQueue":
+		delete(s.queues, p.Get("queueName"))
+		return nil, false
+	case "ListQueue":
+		var names []string
+		for name := range s.queues {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		total := len(names)
+		offset, limit := atoi(p.Get("offset")), atoi(p.Get("limit"))
+		if offset > total {
+			offset = total
+		}
+		names = names[offset:]
+		if limit > 0 && limit < len(names) {
+			names = names[:limit]
+		}
+		var list []map[string]string
+		for _, name := range names {
+			list = append(list, map[string]string{"queueId": name, "queueName": name})
+		}
+		return map[string]interface{}{"totalCount": total, "queueList": list}, false
+	case "PublishMessage", "BatchPublishMessage":
+		bodies := indexed(p, "msgBody")
+		if action == "PublishMessage" {
+			bodies = []string{p.Get("msgBody")}
+		}
+		var list []map[string]string
+		var msgId string
+		for _, body := range bodies {
+			s.seq++
+			msgId = strconv.Itoa(s.seq)
+			s.published[p.Get("topicName")] = append(s.published[p.Get("topicName")], &Message{
+				MsgId:       msgId,
+				Body:        body,
+				Tags:        indexed(p, "msgTag"),
+				RoutingKey:  p.Get("routingKey"),
+				EnqueueTime: time.Now(),
+			})
+			list = append(list, map[string]string{"msgId": msgId})
+		}
+		return map[string]interface{}{"msgId": msgId, "msgList": list}, false
+	}
+	return map[string]interface{}{"code": 4000, "message": "unsupported action " + action}, false
+}
+
+func (s *Server) receive(queueName string, n int) []map[string]interface{} {
+	now := time.Now()
+	var msgs []map[string]interface{}
+	for _, m := range s.queues[queueName] {
+		if len(msgs) >= n {
+			break
+		}
+		if m.visibleAt.After(now) {
+			continue
+		}
+		m.DequeueCount++
+		m.visibleAt = now.Add(s.VisibilityTimeout)
+		s.seq++
+		m.handle = m.MsgId + "-" + strconv.Itoa(s.seq)
+		msgs = append(msgs, map[string]interface{}{
+			"msgId":           m.MsgId,
+			"receiptHandle":   m.handle,
+			"msgBody":         m.Body,
+			"enqueueTime":     m.EnqueueTime.UnixNano() / int64(time.Millisecond),
+			"nextVisibleTime": m.visibleAt.UnixNano() / int64(time.Millisecond),
+			"dequeueCount":    m.DequeueCount,
+		})
+	}
+	return msgs
+}
+
+// 按消息状态统计队列中的消息数
+func (s *Server) queueAttributes(queueName string) map[string]interface{} {
+	now := time.Now()
+	var active, inactive, delay int
+	var minMsgTime int64
+	for _, m := range s.queues[queueName] {
+		switch {
+		case !m.visibleAt.After(now):
+			active++
+		case m.DequeueCount > 0:
+			inactive++
+		default:
+			delay++
+		}
+		if t := m.EnqueueTime.Unix(); minMsgTime == 0 || t < minMsgTime {
+			minMsgTime = t
+		}
+	}
+	return map[string]interface{}{
+		"activeMsgNum":      active,
+		"inactiveMsgNum":    inactive,
+		"delayMsgNum":       delay,
+		"minMsgTime":        minMsgTime,
+		"visibilityTimeout": int(s.VisibilityTimeout / time.Second),
+	}
+}
+
+func (s *Server) delete(queueName, handle string) {
+	q := s.queues[queueName]
+	for i, m := range q {
+		if m.handle == handle {
+			s.queues[queueName] = append(q[:i], q[i+1:]...)
+			return
+		}
+	}
+}
+
+// 按下标顺序取出 key.N 形式的参数
+func indexed(p url.Values, key string) []string {
+	var idx []int
+	values := map[int]string{}
+	for k, v := range p {
+		if strings.HasPrefix(k, key+".") {
+			i, err := strconv.Atoi(k[len(key)+1:])
+			if err == nil {
+				idx = append(idx, i)
+				values[i] = v[0]
+			}
+		}
+	}
+	sort.Ints(idx)
+	var res []string
+	for _, i := range idx {
+		res = append(res, values[i])
+	}
+	return res
+}
+
+func reply(w http.ResponseWriter, v map[string]interface{}) {
+	res := map[string]interface{}{"code": 0, "message": "", "requestId": "cmqtest"}
+	for k, val := range v {
+		res[k] = val
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
@@ -0,0 +1,123 @@
+// 本地预测主题消息会被推送给哪些订阅
+// 实现了 CMQ 主题的两种消息匹配策略：filterTag 标签过滤和 bindingKey 路由匹配
+package matcher
+
+import "strings"
+
+const (
+	// 主题使用 filterTag 标签过滤，与 cmq.FilterTypeTag 相同
+	FilterTypeTag = 1
+	// 主题使用 bindingKey 过滤，与 cmq.FilterTypeBindingKey 相同
+	FilterTypeBindingKey = 2
+)
+
+// 订阅的过滤条件
+type Subscription struct {
+	// 订阅名称
+	Name string
+	// 消息过滤标签
+	FilterTags []string
+	// bindingKey 列表
+	BindingKeys []string
+}
+
+// 发布到主题的消息的路由信息
+type Message struct {
+	// 消息过滤标签
+	Tags []string
+	// 消息路由路径
+	RoutingKey string
+}
+
+// 判断订阅是否会收到消息
+// filterType 为主题的消息匹配策略，0 或 1 表示使用 filterTag 标签过滤，2 表示使用 bindingKey 过滤
+func (s Subscription) Receives(filterType int, m Message) bool {
+	if filterType == FilterTypeBindingKey {
+		return MatchBindingKeys(s.BindingKeys, m.RoutingKey)
+	}
+	return MatchFilterTags(s.FilterTags, m.Tags)
+}
+
+// 返回会收到消息的订阅名称，顺序与 subs 相同
+func Match(filterType int, subs []Subscription, m Message) []string {
+	var names []string
+	for _, s := range subs {
+		if s.Receives(filterType, m) {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// filterTag 和 msgTag 的匹配规则：
+// 1）如果 filterTag 没有设置，则无论 msgTag 是否有设置，订阅接收所有发布到 Topic 的消息；
+// 2）如果 filterTag 数组有值，则只有数组中至少有一个值在 msgTag 数组中也存在时（即 filterTag 和 msgTag 有交集），订阅才接收该消息；
+// 3）如果 filterTag 数组有值，但 msgTag 没设置，则不接收任何消息，是2）的一种特例。
+func MatchFilterTags(filterTags, msgTags []string) bool {
+	if len(filterTags) == 0 {
+		return true
+	}
+	for _, ft := range filterTags {
+		for _, mt := range msgTags {
+			if ft == mt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 只要有一个 bindingKey 与 routingKey 匹配，订阅就接收该消息
+// 没有设置 bindingKey 时与 filterTag 的规则1）一致，接收所有消息
+func MatchBindingKeys(bindingKeys []string, routingKey string) bool {
+	if len(bindingKeys) == 0 {
+		return true
+	}
+	for _, bk := range bindingKeys {
+		if MatchBindingKey(bk, routingKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断 bindingKey 是否与 routingKey 匹配
+// 两者都以“.”分隔为词组，bindingKey 中：
+// *（星号）可以替代一个词组；
+// #（井号）可以替代零个或多个词组；
+// 其他词组必须完全相同。
+func MatchBindingKey(bindingKey, routingKey string) bool {
+	return matchWords(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			// 连续的 # 与单个 # 等价
+			for len(pattern) > 0 && pattern[0] == "#" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(words); i++ {
+				if matchWords(pattern, words[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(words) == 0 {
+				return false
+			}
+		default:
+			if len(words) == 0 || pattern[0] != words[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		words = words[1:]
+	}
+	return len(words) == 0
+}
@@ -0,0 +1,70 @@
+package matcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchBindingKey(t *testing.T) {
+	cases := []struct {
+		bindingKey string
+		routingKey string
+		match      bool
+	}{
+		{"order.created", "order.created", true},
+		{"order.created", "order.deleted", false},
+		{"order.*", "order.created", true},
+		{"order.*", "order.created.eu", false},
+		{"*.created", "order.created", true},
+		{"order.#", "order", true},
+		{"order.#", "order.created.eu", true},
+		{"#", "anything.at.all", true},
+		{"#.eu", "order.created.eu", true},
+		{"#.eu", "order.created.us", false},
+		{"order.#.eu", "order.eu", true},
+		{"order.#.eu", "order.created.paid.eu", true},
+		{"order.*.eu", "order.eu", false},
+		{"order.#.#", "order.created", true},
+		// # 匹配零个词组
+		{"#.eu", "eu", true},
+		{"#.order.#", "order", true},
+		{"order.#.created", "order.created", true},
+		{"order.#.created", "order", false},
+	}
+	for _, c := range cases {
+		if got := MatchBindingKey(c.bindingKey, c.routingKey); got != c.match {
+			t.Errorf("MatchBindingKey(%q, %q) = %v, want %v", c.bindingKey, c.routingKey, got, c.match)
+		}
+	}
+}
+
+func TestMatchFilterTags(t *testing.T) {
+	if !MatchFilterTags(nil, []string{"a"}) || !MatchFilterTags(nil, nil) {
+		t.Error("subscription without filterTag receives all messages")
+	}
+	if !MatchFilterTags([]string{"a", "b"}, []string{"c", "b"}) {
+		t.Error("intersecting tags should match")
+	}
+	if MatchFilterTags([]string{"a"}, []string{"c"}) {
+		t.Error("disjoint tags should not match")
+	}
+	if MatchFilterTags([]string{"a"}, nil) {
+		t.Error("message without msgTag should not match a filtered subscription")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	subs := []Subscription{
+		{Name: "all"},
+		{Name: "orders", FilterTags: []string{"order"}, BindingKeys: []string{"order.#"}},
+		{Name: "eu", FilterTags: []string{"eu"}, BindingKeys: []string{"*.*.eu"}},
+	}
+	m := Message{Tags: []string{"order"}, RoutingKey: "order.created.us"}
+	if got := Match(FilterTypeTag, subs, m); !reflect.DeepEqual(got, []string{"all", "orders"}) {
+		t.Errorf("tag match = %v", got)
+	}
+	m = Message{RoutingKey: "user.created.eu"}
+	if got := Match(FilterTypeBindingKey, subs, m); !reflect.DeepEqual(got, []string{"all", "eu"}) {
+		t.Errorf("bindingKey match = %v", got)
+	}
+}
@@ -0,0 +1,292 @@
+// CMQ 的 OpenTelemetry 链路追踪
+//
+// Interceptor 为每次 CMQ API 调用记录一个 span，并在发送消息时把 W3C trace context
+// 放到消息信封（cmq.Envelope）的消息头中；消费端用 Extract、StartProcessSpan 或
+// WrapNotificationHandler 取出 trace context，使链路跨过队列/主题延续下去。
+package otelcmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/zyw/cmq-goclient/cmq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/zyw/cmq-goclient/otelcmq"
+
+// 队列、主题的消息最大长度属性的最小值，消息正文不超过这个长度时不需要查询目的地的属性
+const minMsgSizeLimit = 1024
+
+const (
+	systemKey      = attribute.Key("messaging.system")
+	destinationKey = attribute.Key("messaging.destination.name")
+	messageIdKey   = attribute.Key("messaging.message.id")
+	actionKey      = attribute.Key("cmq.action")
+	requestIdKey   = attribute.Key("cmq.request_id")
+	codeKey        = attribute.Key("cmq.code")
+	retryCountKey  = attribute.Key("cmq.retry_count")
+)
+
+type config struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// 配置项
+type Option func(*config)
+
+// 指定 TracerProvider，默认使用 otel.GetTracerProvider()
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracer = tp.Tracer(instrumentationName)
+	}
+}
+
+// 指定传播格式，默认使用 W3C trace context
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = p
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		tracer:     otel.GetTracerProvider().Tracer(instrumentationName),
+		propagator: propagation.TraceContext{},
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// 响应中用于记录到 span 的字段
+type response struct {
+	Code      int    `json:"code"`
+	RequestId string `json:"requestId"`
+	MsgId     string `json:"msgId"`
+}
+
+// 返回记录 span 的拦截器，通过 CmqConfig.AddInterceptor 添加
+// 发送消息（SendMessage、BatchSendMessage、PublishMessage、BatchPublishMessage）时
+// 把 trace context 注入消息信封的消息头
+// 需要用 Queue.WithContext、Topic.WithContext 传入调用方的 ctx 才能和上游链路关联
+// 注入后超过目的地的消息最大长度时不注入，目的地的 maxMsgSize 在第一次发送较大的消息时查询并缓存
+func Interceptor(opts ...Option) cmq.Interceptor {
+	c := newConfig(opts)
+	limits := &msgSizeLimits{limits: map[string]int{}}
+	return func(ctx context.Context, action string, params map[string]interface{}, invoker cmq.Invoker) (string, *cmq.CMQError) {
+		dest := destination(params)
+		ctx, span := c.tracer.Start(ctx, "CMQ "+action,
+			trace.WithSpanKind(spanKind(action)),
+			trace.WithAttributes(
+				systemKey.String("cmq"),
+				actionKey.String(action),
+				destinationKey.String(dest),
+			))
+		defer span.End()
+
+		if spanKind(action) == trace.SpanKindProducer {
+			headers := map[string]string{}
+			c.propagator.Inject(ctx, propagation.MapCarrier(headers))
+			limit := func() int {
+				return limits.get(ctx, params, invoker)
+			}
+			if len(headers) != 0 && !injectHeaders(action, params, headers, limit) {
+				span.AddEvent("trace context not injected, message body too large")
+			}
+		}
+
+		ctx = cmq.WithRetryCounter(ctx)
+		result, err := invoker(ctx, action, params)
+		span.SetAttributes(retryCountKey.Int(cmq.Retries(ctx)))
+
+		var res response
+		if json.Unmarshal([]byte(result), &res) == nil {
+			if len(res.RequestId) != 0 {
+				span.SetAttributes(requestIdKey.String(res.RequestId))
+			}
+			if len(res.MsgId) != 0 {
+				span.SetAttributes(messageIdKey.String(res.MsgId))
+			}
+		}
+		if err != nil {
+			span.SetAttributes(codeKey.Int(int(err.Code)))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(codeKey.Int(res.Code))
+			if res.Code != 0 {
+				span.SetStatus(codes.Error, fmt.Sprintf("code:%d", res.Code))
+			}
+		}
+		return result, err
+	}
+}
+
+// 从消息头中取出 trace context，返回带有远端 span context 的 ctx，不修改 m
+// 消息正文还是信封格式时（比如不是通过 ReceiveMessage 得到的消息），从信封的消息头中取出
+func Extract(ctx context.Context, m *cmq.Message, opts ...Option) context.Context {
+	c := newConfig(opts)
+	headers := m.Headers
+	if headers == nil {
+		_, headers, _ = cmq.UnwrapBody(m.MsgBody)
+	}
+	if headers == nil {
+		return ctx
+	}
+	return c.propagator.Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// 开始处理一条从队列中拉取的消息，返回的 span 以生产者的 span 为父 span，处理完成后需要调用 span.End()
+func StartProcessSpan(ctx context.Context, queueName string, m *cmq.Message, opts ...Option) (context.Context, trace.Span) {
+	c := newConfig(opts)
+	ctx = Extract(ctx, m, opts...)
+	return c.tracer.Start(ctx, "CMQ process "+queueName,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			systemKey.String("cmq"),
+			destinationKey.String(queueName),
+			messageIdKey.String(m.MsgId),
+		))
+}
+
+// 包装 http 订阅的推送处理函数，从推送的消息中取出 trace context 并记录处理 span
+func WrapNotificationHandler(h cmq.NotificationHandler, opts ...Option) cmq.NotificationHandler {
+	c := newConfig(opts)
+	return func(ctx context.Context, n *cmq.Notification) error {
+		if n.Headers == nil {
+			n.MsgBody, n.Headers, _ = cmq.UnwrapBody(n.MsgBody)
+		}
+		if n.Headers != nil {
+			ctx = c.propagator.Extract(ctx, propagation.MapCarrier(n.Headers))
+		}
+		ctx, span := c.tracer.Start(ctx, "CMQ process "+n.TopicName,
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				systemKey.String("cmq"),
+				destinationKey.String(n.TopicName),
+				messageIdKey.String(n.MsgId),
+				attribute.String("cmq.subscription", n.SubscriptionName),
+			))
+		defer span.End()
+
+		err := h(ctx, n)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+func destination(params map[string]interface{}) string {
+	if qn, ok := params["queueName"].(string); ok {
+		return qn
+	}
+	if tn, ok := params["topicName"].(string); ok {
+		return tn
+	}
+	return ""
+}
+
+// 把消息头加入 params 中的所有消息正文
+// 加入后超过单条消息的长度上限 limit() 或批量发送的长度上限时不修改 params，返回 false
+func injectHeaders(action string, params map[string]interface{}, headers map[string]string, limit func() int) bool {
+	bodies := map[string]string{}
+	total := 0
+	for k, v := range params {
+		if body, ok := v.(string); ok && isMsgBody(k) {
+			bodies[k] = cmq.AddHeaders(body, headers)
+			if len(bodies[k]) > minMsgSizeLimit && len(bodies[k]) > limit() {
+				return false
+			}
+			total += len(bodies[k])
+		}
+	}
+	batch := action == cmq.BatchSendMessage || action == cmq.BatchPublishMessage
+	if batch && total > cmq.MaxBatchBodySize {
+		return false
+	}
+	for k, body := range bodies {
+		params[k] = body
+	}
+	return true
+}
+
+// 每个目的地的消息最大长度
+type msgSizeLimits struct {
+	mu     sync.Mutex
+	limits map[string]int
+}
+
+// 目的地的消息最大长度，不超过 cmq.DefaultMaxMsgSize；查询失败时返回 cmq.DefaultMaxMsgSize，下次再查询
+func (l *msgSizeLimits) get(ctx context.Context, params map[string]interface{}, invoker cmq.Invoker) int {
+	action, key := cmq.GetQueueAttributes, "queueName"
+	if _, ok := params[key]; !ok {
+		action, key = cmq.GetTopicAttributes, "topicName"
+	}
+	name := destination(params)
+	l.mu.Lock()
+	limit, ok := l.limits[key+" "+name]
+	l.mu.Unlock()
+	if ok {
+		return limit
+	}
+
+	result, err := invoker(ctx, action, map[string]interface{}{key: name})
+	var res struct {
+		Code       int `json:"code"`
+		MaxMsgSize int `json:"maxMsgSize"`
+	}
+	if err != nil || json.Unmarshal([]byte(result), &res) != nil || res.Code != 0 || res.MaxMsgSize <= 0 {
+		return cmq.DefaultMaxMsgSize
+	}
+	limit = res.MaxMsgSize
+	if limit > cmq.DefaultMaxMsgSize {
+		limit = cmq.DefaultMaxMsgSize
+	}
+	l.mu.Lock()
+	l.limits[key+" "+name] = limit
+	l.mu.Unlock()
+	return limit
+}
+
+func isMsgBody(key string) bool {
+	return key == "msgBody" || strings.HasPrefix(key, "msgBody.")
+}
+
+func spanKind(action string) trace.SpanKind {
+	switch action {
+	case cmq.SendMessage, cmq.BatchSendMessage, cmq.PublishMessage, cmq.BatchPublishMessage:
+		return trace.SpanKindProducer
+	case cmq.ReceiveMessage, cmq.BatchReceiveMessage:
+		return trace.SpanKindConsumer
+	}
+	return trace.SpanKindClient
+}
+
+// 返回消费者（cmq.Consumer）的 Handler 中间件，从消息中取出 trace context 并记录处理 span
+func Middleware(queueName string, opts ...Option) cmq.HandlerMiddleware {
+	return func(next cmq.Handler) cmq.Handler {
+		return func(ctx context.Context, m *cmq.Message) error {
+			ctx, span := StartProcessSpan(ctx, queueName, m, opts...)
+			defer span.End()
+
+			err := next(ctx, m)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		}
+	}
+}
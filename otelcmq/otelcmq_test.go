@@ -0,0 +1,179 @@
+package otelcmq
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zyw/cmq-goclient/cmq"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInterceptor(t *testing.T) {
+	var sent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sent = r.PostForm.Get("msgBody")
+		w.Write([]byte(`{"code":0,"message":"","requestId":"req-1","msgId":"msg-1"}`))
+	}))
+	defer server.Close()
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	account := cmq.NewAccountDefault(server.URL, "id", "key")
+	account.AddInterceptor(Interceptor(WithTracerProvider(tp)))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	if _, err := account.GetQueue("queue-a").WithContext(ctx).SendMessage("hello", 0); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("want 2 spans, got %d", len(spans))
+	}
+	send := spans[0]
+	if send.Name() != "CMQ SendMessage" || send.SpanKind() != trace.SpanKindProducer {
+		t.Errorf("unexpected span %s %v", send.Name(), send.SpanKind())
+	}
+	if send.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("send span should be a child of the caller span")
+	}
+	attrs := map[string]string{}
+	for _, kv := range send.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["cmq.request_id"] != "req-1" || attrs["messaging.destination.name"] != "queue-a" || attrs["messaging.message.id"] != "msg-1" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+
+	m := &cmq.Message{MsgId: "msg-1", MsgBody: sent}
+	_, span := StartProcessSpan(context.Background(), "queue-a", m, WithTracerProvider(tp))
+	span.End()
+	if m.MsgBody != sent {
+		t.Errorf("body = %q, StartProcessSpan should not modify the message", m.MsgBody)
+	}
+	process := sr.Ended()[2]
+	if process.Parent().SpanID() != send.SpanContext().SpanID() || process.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Error("process span should continue the producer trace")
+	}
+}
+
+func TestExtractPlainBody(t *testing.T) {
+	m := &cmq.Message{MsgBody: "plain"}
+	ctx := Extract(context.Background(), m)
+	if trace.SpanContextFromContext(ctx).IsValid() || m.MsgBody != "plain" {
+		t.Error("plain body should be left untouched")
+	}
+}
+
+func TestInterceptor_RetryCount(t *testing.T) {
+	dead := httptest.NewServer(nil)
+	dead.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":0,"message":"","requestId":"req-1","msgId":"msg-1"}`))
+	}))
+	defer server.Close()
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	account := cmq.NewAccountDefault(dead.URL, "id", "key")
+	account.AddQueueEndpoint(server.URL, 1)
+	account.AddInterceptor(Interceptor(WithTracerProvider(tp)))
+	if _, err := account.GetQueue("queue-a").SendMessage("hello", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kv := range sr.Ended()[0].Attributes() {
+		if kv.Key == retryCountKey {
+			if kv.Value.AsInt64() != 1 {
+				t.Errorf("retry count = %d, want 1", kv.Value.AsInt64())
+			}
+			return
+		}
+	}
+	t.Error("retry count attribute not recorded")
+}
+
+func TestInterceptor_LargeBody(t *testing.T) {
+	var sent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sent = r.PostForm.Get("msgBody")
+		w.Write([]byte(`{"code":0,"message":"","requestId":"req-1","msgId":"msg-1"}`))
+	}))
+	defer server.Close()
+
+	tp := sdktrace.NewTracerProvider()
+	account := cmq.NewAccountDefault(server.URL, "id", "key")
+	account.AddInterceptor(Interceptor(WithTracerProvider(tp)))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	defer parent.End()
+
+	// 正文已经是最大长度，加上信封会超过限制，不注入 trace context
+	body := strings.Repeat("x", cmq.DefaultMaxMsgSize)
+	if _, err := account.GetQueue("queue-a").WithContext(ctx).SendMessage(body, 0); err != nil {
+		t.Fatal(err)
+	}
+	if sent != body {
+		t.Errorf("sent %d bytes, want the original body", len(sent))
+	}
+}
+
+func TestInterceptor_QueueMaxMsgSize(t *testing.T) {
+	var sent []string
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		calls = append(calls, r.PostForm.Get("Action"))
+		if r.PostForm.Get("Action") == cmq.GetQueueAttributes {
+			w.Write([]byte(`{"code":0,"maxMsgSize":2048}`))
+			return
+		}
+		sent = append(sent, r.PostForm.Get("msgBody"))
+		w.Write([]byte(`{"code":0,"message":"","requestId":"req-1","msgId":"msg-1"}`))
+	}))
+	defer server.Close()
+
+	tp := sdktrace.NewTracerProvider()
+	account := cmq.NewAccountDefault(server.URL, "id", "key")
+	account.AddInterceptor(Interceptor(WithTracerProvider(tp)))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	defer parent.End()
+
+	// 队列的 maxMsgSize 为 2048，加上信封会超过限制，不注入 trace context
+	q := account.GetQueue("queue-a").WithContext(ctx)
+	for _, body := range []string{strings.Repeat("x", 2000), "small"} {
+		if _, err := q.SendMessage(body, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent[0] != strings.Repeat("x", 2000) {
+		t.Errorf("sent %d bytes, want the original body", len(sent[0]))
+	}
+	if _, headers, ok := cmq.UnwrapBody(sent[1]); !ok || len(headers["traceparent"]) == 0 {
+		t.Errorf("small body should carry trace context, sent %q", sent[1])
+	}
+	if strings.Join(calls, ",") != "GetQueueAttributes,SendMessage,SendMessage" {
+		t.Errorf("calls = %v, maxMsgSize should be queried once", calls)
+	}
+}
+
+func TestExtract_NoMutation(t *testing.T) {
+	body := cmq.WrapBody("hello", map[string]string{
+		"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	})
+	m := &cmq.Message{MsgBody: body}
+	ctx := Extract(context.Background(), m)
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		t.Error("trace context should be extracted from the envelope")
+	}
+	if m.MsgBody != body || m.Headers != nil {
+		t.Error("Extract should not modify the message")
+	}
+}
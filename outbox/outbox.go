@@ -0,0 +1,279 @@
+// 基于 database/sql 的事务性发件箱（transactional outbox）
+//
+// 业务代码在自己的事务中调用 Outbox.AddQueueMessage/AddTopicMessage，把待发送的消息
+// 和业务数据一起写入数据库；事务提交后由 Relay 轮询发件箱表，按写入顺序批量发送到 CMQ，
+// 并把发送成功的记录标记为已发送。消息至少发送一次，消费端需要能处理重复消息，
+// 可以使用 cmqidem.SQLStore 和 cmq.Deduplicator 去重。
+//
+//	ob := outbox.New(db)
+//	ob.CreateTable(ctx)
+//
+//	tx, _ := db.BeginTx(ctx, nil)
+//	tx.Exec("INSERT INTO orders ...")
+//	ob.AddQueueMessage(ctx, tx, "order-events", &cmq.ProducerMessage{Body: "..."})
+//	tx.Commit()
+//
+//	go outbox.NewRelay(ob, account).Run(ctx)
+//
+// 同一个发件箱表只应该运行一个 Relay，否则消息可能重复发送或乱序。
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmq"
+)
+
+// 缺省的发件箱表名
+const DefaultTable = "cmq_outbox"
+
+// 记录状态
+const (
+	StatusPending = 0
+	StatusSent    = 1
+	//超过最大重试次数，不再发送
+	StatusFailed = 2
+)
+
+// SQL 方言，决定参数占位符和建表语句
+type Dialect int
+
+const (
+	//SQLite，使用 ? 作为占位符
+	DialectSQLite Dialect = iota
+	//PostgreSQL，使用 $1、$2 作为占位符
+	DialectPostgres
+	//MySQL，使用 ? 作为占位符
+	DialectMySQL
+)
+
+// 发件箱表中的一条记录
+type Record struct {
+	Id int64
+	//目的地类型：cmq.ResourceQueue 或 cmq.ResourceTopic
+	Kind string
+	//队列名或主题名
+	Name    string
+	Message *cmq.ProducerMessage
+	//已经尝试发送的次数
+	Attempts int
+	//下次尝试发送的时间
+	NextAttempt time.Time
+	LastError   string
+	CreateTime  time.Time
+}
+
+// 发件箱，写入待发送的消息
+type Outbox struct {
+	db      *sql.DB
+	table   string
+	dialect Dialect
+}
+
+func New(db *sql.DB) *Outbox {
+	return &Outbox{
+		db:      db,
+		table:   DefaultTable,
+		dialect: DialectSQLite,
+	}
+}
+
+// 设置表名
+func (o *Outbox) SetTable(table string) {
+	o.table = table
+}
+
+// 设置 SQL 方言，默认为 DialectSQLite
+func (o *Outbox) SetDialect(d Dialect) {
+	o.dialect = d
+}
+
+// 创建发件箱表（如果不存在）
+func (o *Outbox) CreateTable(ctx context.Context) error {
+	id := "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	switch o.dialect {
+	case DialectPostgres:
+		id = "id BIGSERIAL PRIMARY KEY"
+	case DialectMySQL:
+		id = "id BIGINT AUTO_INCREMENT PRIMARY KEY"
+	}
+	stmt := `CREATE TABLE IF NOT EXISTS ` + o.table + ` (
+	` + id + `,
+	kind VARCHAR(16) NOT NULL,
+	name VARCHAR(128) NOT NULL,
+	body TEXT NOT NULL,
+	delay_seconds INTEGER NOT NULL DEFAULT 0,
+	tags TEXT NOT NULL,
+	routing_key VARCHAR(128) NOT NULL DEFAULT '',
+	status INTEGER NOT NULL DEFAULT 0,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt BIGINT NOT NULL,
+	last_error TEXT NOT NULL,
+	msg_id VARCHAR(64) NOT NULL DEFAULT '',
+	created_at BIGINT NOT NULL,
+	sent_at BIGINT NOT NULL DEFAULT 0
+)`
+	if _, err := o.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	index := o.table + `_status`
+	exists, err := o.indexExists(ctx, index)
+	if err != nil || exists {
+		return err
+	}
+	_, err = o.db.ExecContext(ctx, `CREATE INDEX `+o.ifNotExists()+index+` ON `+o.table+` (status, id)`)
+	return err
+}
+
+// MySQL 不支持 CREATE INDEX IF NOT EXISTS，先查询索引是否存在；其他数据库由 IF NOT EXISTS 处理
+func (o *Outbox) indexExists(ctx context.Context, index string) (bool, error) {
+	if o.dialect != DialectMySQL {
+		return false, nil
+	}
+	var n int
+	err := o.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM information_schema.statistics`+
+		` WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`, o.table, index).Scan(&n)
+	return n > 0, err
+}
+
+func (o *Outbox) ifNotExists() string {
+	if o.dialect == DialectMySQL {
+		return ""
+	}
+	return "IF NOT EXISTS "
+}
+
+// 在事务 tx 中写入一条发送到队列的消息
+func (o *Outbox) AddQueueMessage(ctx context.Context, tx *sql.Tx, queueName string, m *cmq.ProducerMessage) error {
+	return o.add(ctx, tx, cmq.ResourceQueue, queueName, m)
+}
+
+// 在事务 tx 中写入一条发布到主题的消息
+func (o *Outbox) AddTopicMessage(ctx context.Context, tx *sql.Tx, topicName string, m *cmq.ProducerMessage) error {
+	return o.add(ctx, tx, cmq.ResourceTopic, topicName, m)
+}
+
+func (o *Outbox) add(ctx context.Context, tx *sql.Tx, kind, name string, m *cmq.ProducerMessage) error {
+	tags, err := json.Marshal(m.Tags)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	_, err = tx.ExecContext(ctx, o.rebind(`INSERT INTO `+o.table+
+		` (kind, name, body, delay_seconds, tags, routing_key, status, attempts, next_attempt, last_error, created_at)`+
+		` VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, '', ?)`),
+		kind, name, cmq.WrapBody(m.Body, m.Headers), m.DelaySeconds, string(tags), m.RoutingKey, StatusPending, now, now)
+	return err
+}
+
+// 待发送的记录数
+func (o *Outbox) Pending(ctx context.Context) (int, error) {
+	var n int
+	err := o.db.QueryRowContext(ctx, o.rebind(`SELECT COUNT(*) FROM `+o.table+` WHERE status = ?`), StatusPending).Scan(&n)
+	return n, err
+}
+
+// 删除发送时间早于 before 的已发送记录，返回删除的记录数
+func (o *Outbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := o.db.ExecContext(ctx, o.rebind(`DELETE FROM `+o.table+` WHERE status = ? AND sent_at < ?`),
+		StatusSent, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// 按 id 顺序读取最多 limit 条现在可以发送的记录
+// 一个目的地中有记录在等待重试时，跳过这条记录和同一目的地后面的记录，避免它们占满 limit 使其他目的地无法发送
+func (o *Outbox) pending(ctx context.Context, limit int, now time.Time) ([]*Record, error) {
+	rows, err := o.db.QueryContext(ctx, o.rebind(`SELECT id, kind, name, body, delay_seconds, tags, routing_key,`+
+		` attempts, next_attempt, last_error, created_at FROM `+o.table+` r WHERE status = ?`+
+		` AND NOT EXISTS (SELECT 1 FROM `+o.table+` b WHERE b.status = ? AND b.kind = r.kind AND b.name = r.name`+
+		` AND b.id <= r.id AND b.next_attempt > ?) ORDER BY id LIMIT ?`),
+		StatusPending, StatusPending, now.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []*Record
+	for rows.Next() {
+		r := &Record{Message: &cmq.ProducerMessage{}}
+		var body, tags string
+		var next, created int64
+		if err := rows.Scan(&r.Id, &r.Kind, &r.Name, &body, &r.Message.DelaySeconds, &tags,
+			&r.Message.RoutingKey, &r.Attempts, &next, &r.LastError, &created); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(tags), &r.Message.Tags); err != nil {
+			return nil, fmt.Errorf("invalid tags of outbox record %d: %v", r.Id, err)
+		}
+		r.Message.Body, r.Message.Headers, _ = cmq.UnwrapBody(body)
+		r.NextAttempt = time.Unix(0, next)
+		r.CreateTime = time.Unix(0, created)
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// 标记记录已发送
+func (o *Outbox) markSent(ctx context.Context, records []*Record, msgIds []string) error {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, o.rebind(`UPDATE `+o.table+
+		` SET status = ?, attempts = attempts + 1, msg_id = ?, sent_at = ? WHERE id = ?`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().UnixNano()
+	for i, r := range records {
+		var msgId string
+		if i < len(msgIds) {
+			msgId = msgIds[i]
+		}
+		if _, err := stmt.ExecContext(ctx, StatusSent, msgId, now, r.Id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 记录一次发送失败，status 为 StatusPending 时在 next 之后重试
+func (o *Outbox) markFailed(ctx context.Context, records []*Record, status int, next time.Time, cause string) error {
+	ids := make([]string, len(records))
+	for i, r := range records {
+		ids[i] = strconv.FormatInt(r.Id, 10)
+	}
+	_, err := o.db.ExecContext(ctx, o.rebind(`UPDATE `+o.table+
+		` SET status = ?, attempts = attempts + 1, next_attempt = ?, last_error = ? WHERE id IN (`+strings.Join(ids, ",")+`)`),
+		status, next.UnixNano(), cause)
+	return err
+}
+
+// 把 ? 占位符转换为方言使用的占位符
+func (o *Outbox) rebind(query string) string {
+	if o.dialect != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,225 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/zyw/cmq-goclient/cmq"
+	"github.com/zyw/cmq-goclient/cmqtest"
+)
+
+func newOutbox(t *testing.T) *Outbox {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	o := New(db)
+	if err := o.CreateTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func add(t *testing.T, o *Outbox, commit bool, kind, name string, msgs ...*cmq.ProducerMessage) {
+	ctx := context.Background()
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range msgs {
+		if kind == cmq.ResourceQueue {
+			err = o.AddQueueMessage(ctx, tx, name, m)
+		} else {
+			err = o.AddTopicMessage(ctx, tx, name, m)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if commit {
+		err = tx.Commit()
+	} else {
+		err = tx.Rollback()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelay_RelayOnce(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	o := newOutbox(t)
+
+	add(t, o, true, cmq.ResourceQueue, "queue-a",
+		&cmq.ProducerMessage{Body: "a"}, &cmq.ProducerMessage{Body: "b"}, &cmq.ProducerMessage{Body: "c", DelaySeconds: 10})
+	add(t, o, false, cmq.ResourceQueue, "queue-a", &cmq.ProducerMessage{Body: "rolled back"})
+	add(t, o, true, cmq.ResourceTopic, "topic-a",
+		&cmq.ProducerMessage{Body: "x", Tags: []string{"t1"}}, &cmq.ProducerMessage{Body: "y", Tags: []string{"t1"}})
+
+	r := NewRelay(o, cmq.NewAccountDefault(s.URL, "id", "key"))
+	n, err := r.RelayOnce(context.Background())
+	if err != nil || n != 5 {
+		t.Fatalf("RelayOnce() = %d, %v", n, err)
+	}
+
+	msgs := s.Messages("queue-a")
+	if len(msgs) != 3 || msgs[0].Body != "a" || msgs[1].Body != "b" || msgs[2].Body != "c" {
+		t.Errorf("unexpected queue messages %+v", msgs)
+	}
+	if pub := s.Published("topic-a"); len(pub) != 2 || pub[0].Tags[0] != "t1" {
+		t.Errorf("unexpected published messages %+v", pub)
+	}
+	want := []string{cmq.BatchSendMessage, cmq.SendMessage, cmq.BatchPublishMessage}
+	if calls := s.Calls(); len(calls) != len(want) || calls[0] != want[0] || calls[1] != want[1] || calls[2] != want[2] {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if n, _ := o.Pending(context.Background()); n != 0 {
+		t.Errorf("Pending() = %d", n)
+	}
+	if n, err := o.Purge(context.Background(), time.Now().Add(time.Second)); err != nil || n != 5 {
+		t.Errorf("Purge() = %d, %v", n, err)
+	}
+}
+
+func TestRelay_Retry(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	o := newOutbox(t)
+	add(t, o, true, cmq.ResourceQueue, "queue-a", &cmq.ProducerMessage{Body: "a"})
+	add(t, o, true, cmq.ResourceQueue, "queue-a", &cmq.ProducerMessage{Body: "b", DelaySeconds: 5})
+
+	now := time.Now()
+	r := NewRelay(o, cmq.NewAccountDefault(s.URL, "id", "key"))
+	r.now = func() time.Time { return now }
+	s.FailNext(cmq.SendMessage, 6000)
+
+	// 第一条消息失败，第二条不能越过它先发送
+	if n, err := r.RelayOnce(context.Background()); err != nil || n != 0 {
+		t.Fatalf("RelayOnce() = %d, %v", n, err)
+	}
+	if n, _ := r.RelayOnce(context.Background()); n != 0 {
+		t.Fatalf("sent %d messages before backoff", n)
+	}
+	records, _ := o.pending(context.Background(), 10, now.Add(time.Hour))
+	if records[0].Attempts != 1 || records[0].LastError == "" || !records[0].NextAttempt.Equal(now.Add(DefaultMinBackoff)) {
+		t.Errorf("unexpected record %+v", records[0])
+	}
+
+	now = now.Add(DefaultMinBackoff)
+	if n, err := r.RelayOnce(context.Background()); err != nil || n != 2 {
+		t.Fatalf("RelayOnce() = %d, %v", n, err)
+	}
+	if msgs := s.Messages("queue-a"); len(msgs) != 2 || msgs[0].Body != "a" || msgs[1].Body != "b" {
+		t.Errorf("unexpected queue messages %+v", msgs)
+	}
+}
+
+func TestRelay_MaxAttempts(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	o := newOutbox(t)
+	add(t, o, true, cmq.ResourceQueue, "queue-a", &cmq.ProducerMessage{Body: "a"})
+
+	r := NewRelay(o, cmq.NewAccountDefault(s.URL, "id", "key"))
+	r.SetMaxAttempts(1)
+	s.FailNext(cmq.SendMessage, 6000)
+	r.RelayOnce(context.Background())
+
+	if n, _ := o.Pending(context.Background()); n != 0 {
+		t.Errorf("Pending() = %d, want failed record skipped", n)
+	}
+	if len(s.Messages("queue-a")) != 0 {
+		t.Error("failed record should not be sent")
+	}
+}
+
+func TestOutbox_Rebind(t *testing.T) {
+	o := &Outbox{dialect: DialectPostgres}
+	if q := o.rebind("a = ? AND b = ?"); q != "a = $1 AND b = $2" {
+		t.Errorf("rebind() = %q", q)
+	}
+}
+
+func TestRelay_PermanentError(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	o := newOutbox(t)
+	add(t, o, true, cmq.ResourceQueue, "queue-a", &cmq.ProducerMessage{Body: "a"})
+
+	r := NewRelay(o, cmq.NewAccountDefault(s.URL, "id", "key"))
+	s.FailNext(cmq.SendMessage, 4000)
+	if n, err := r.RelayOnce(context.Background()); err != nil || n != 0 {
+		t.Fatalf("RelayOnce() = %d, %v", n, err)
+	}
+	if n, _ := o.Pending(context.Background()); n != 0 {
+		t.Errorf("Pending() = %d, server error should not be retried", n)
+	}
+}
+
+func TestRelay_NoStarvation(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	o := newOutbox(t)
+	for i := 0; i < 4; i++ {
+		add(t, o, true, cmq.ResourceQueue, "queue-a", &cmq.ProducerMessage{Body: "a", DelaySeconds: i})
+	}
+	add(t, o, true, cmq.ResourceQueue, "queue-b", &cmq.ProducerMessage{Body: "b"})
+
+	now := time.Now()
+	r := NewRelay(o, cmq.NewAccountDefault(s.URL, "id", "key"))
+	r.now = func() time.Time { return now }
+	r.SetFetchSize(2)
+	s.FailNext(cmq.SendMessage, 6000)
+
+	// queue-a 的第一条消息等待重试，读取的记录数不能被 queue-a 占满
+	if n, err := r.RelayOnce(context.Background()); err != nil || n != 0 {
+		t.Fatalf("RelayOnce() = %d, %v", n, err)
+	}
+	if n, err := r.RelayOnce(context.Background()); err != nil || n != 1 {
+		t.Fatalf("RelayOnce() = %d, %v, want queue-b relayed", n, err)
+	}
+	if msgs := s.Messages("queue-b"); len(msgs) != 1 {
+		t.Errorf("queue-b messages %+v", msgs)
+	}
+	if len(s.Messages("queue-a")) != 0 {
+		t.Error("queue-a messages should wait for the first one")
+	}
+}
+
+func TestRelay_InvalidRecordInBatch(t *testing.T) {
+	s := cmqtest.NewServer()
+	defer s.Close()
+	o := newOutbox(t)
+	add(t, o, true, cmq.ResourceQueue, "queue-a",
+		&cmq.ProducerMessage{Body: "a"}, &cmq.ProducerMessage{Body: ""}, &cmq.ProducerMessage{Body: "c"})
+
+	r := NewRelay(o, cmq.NewAccountDefault(s.URL, "id", "key"))
+	if n, err := r.RelayOnce(context.Background()); err != nil || n != 2 {
+		t.Fatalf("RelayOnce() = %d, %v, want the valid records relayed", n, err)
+	}
+	msgs := s.Messages("queue-a")
+	if len(msgs) != 2 || msgs[0].Body != "a" || msgs[1].Body != "c" {
+		t.Errorf("queue-a messages %+v", msgs)
+	}
+	if n, _ := o.Pending(context.Background()); n != 0 {
+		t.Errorf("Pending() = %d, invalid record should be failed", n)
+	}
+	var failed int
+	o.db.QueryRow(`SELECT COUNT(*) FROM `+o.table+` WHERE status = ?`, StatusFailed).Scan(&failed)
+	if failed != 1 {
+		t.Errorf("failed records = %d, want 1", failed)
+	}
+}
+
+func TestOutbox_CreateTableTwice(t *testing.T) {
+	o := newOutbox(t)
+	if err := o.CreateTable(context.Background()); err != nil {
+		t.Errorf("second CreateTable() = %v", err)
+	}
+}
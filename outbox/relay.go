@@ -0,0 +1,245 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zyw/cmq-goclient/cmq"
+)
+
+const (
+	//缺省的轮询间隔
+	DefaultPollInterval = time.Second
+	//每次轮询读取的最大记录数
+	DefaultFetchSize = 256
+	//发送失败后第一次重试的等待时间，之后每次翻倍
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 5 * time.Minute
+)
+
+// 把发件箱中的消息转发到 CMQ
+// 同一个队列或主题的消息按写入顺序发送：一条消息发送失败后，排在它后面的消息等它发送成功后才发送
+type Relay struct {
+	outbox      *Outbox
+	account     *cmq.CmqConfig
+	interval    time.Duration
+	fetchSize   int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+	now         func() time.Time
+}
+
+func NewRelay(o *Outbox, account *cmq.CmqConfig) *Relay {
+	return &Relay{
+		outbox:     o,
+		account:    account,
+		interval:   DefaultPollInterval,
+		fetchSize:  DefaultFetchSize,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		now:        time.Now,
+	}
+}
+
+// 设置没有待发送消息时的轮询间隔
+func (r *Relay) SetPollInterval(interval time.Duration) {
+	if interval > 0 {
+		r.interval = interval
+	}
+}
+
+// 设置每次轮询读取的最大记录数
+func (r *Relay) SetFetchSize(n int) {
+	if n > 0 {
+		r.fetchSize = n
+	}
+}
+
+// 设置发送失败后的重试间隔，从 min 开始每次翻倍，最大为 max
+func (r *Relay) SetBackoff(min, max time.Duration) {
+	r.minBackoff = min
+	r.maxBackoff = max
+}
+
+// 设置最大发送次数，超过后记录标记为 StatusFailed 不再发送，0 表示临时错误一直重试
+func (r *Relay) SetMaxAttempts(n int) {
+	r.maxAttempts = n
+}
+
+// 持续转发发件箱中的消息，直到 ctx 结束
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil {
+			log.Println("relay outbox error, msg: " + err.Error())
+		}
+		if err == nil && n > 0 {
+			// 可能还有没读取的记录，马上继续
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		t := time.NewTimer(r.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// 转发一次待发送的消息，返回发送成功的消息数
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	now := r.now()
+	records, err := r.outbox.pending(ctx, r.fetchSize, now)
+	if err != nil {
+		return 0, err
+	}
+
+	// 按目的地分组，保持写入顺序
+	var keys []string
+	groups := map[string][]*Record{}
+	for _, rec := range records {
+		key := rec.Kind + " " + rec.Name
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], rec)
+	}
+
+	sent := 0
+	for _, key := range keys {
+		pending := groups[key]
+		for len(pending) > 0 && !pending[0].NextAttempt.After(now) {
+			batch := nextBatch(pending, now)
+			pending = pending[len(batch):]
+			msgIds, cerr := r.send(ctx, batch)
+			if cerr != nil && len(batch) > 1 && !cmq.IsTemporary(cerr) {
+				// 一条消息不合法会导致整批失败，逐条重新发送，只把真正失败的记录标记为 StatusFailed
+				n, ok, err := r.sendEach(ctx, batch)
+				sent += n
+				if err != nil {
+					return sent, err
+				}
+				if !ok {
+					break
+				}
+				continue
+			}
+			if cerr != nil {
+				if err := r.failed(ctx, batch, cerr); err != nil {
+					return sent, err
+				}
+				break
+			}
+			if err := r.outbox.markSent(ctx, batch, msgIds); err != nil {
+				return sent, err
+			}
+			sent += len(batch)
+		}
+	}
+	return sent, nil
+}
+
+// 从 records 开头取出可以一次批量发送的记录：
+// 队列消息的 delaySeconds 相同，主题消息的 tags 和 routingKey 相同，且不超过批量发送的限制
+func nextBatch(records []*Record, now time.Time) []*Record {
+	first := records[0].Message
+	size := len(cmq.WrapBody(first.Body, first.Headers))
+	n := 1
+	for ; n < len(records) && n < cmq.MaxBatchSize; n++ {
+		rec := records[n]
+		m := rec.Message
+		body := cmq.WrapBody(m.Body, m.Headers)
+		if rec.NextAttempt.After(now) || size+len(body) > cmq.MaxBatchBodySize {
+			break
+		}
+		if rec.Kind == cmq.ResourceQueue && m.DelaySeconds != first.DelaySeconds {
+			break
+		}
+		if rec.Kind == cmq.ResourceTopic &&
+			(m.RoutingKey != first.RoutingKey || strings.Join(m.Tags, "\x00") != strings.Join(first.Tags, "\x00")) {
+			break
+		}
+		size += len(body)
+	}
+	return records[:n]
+}
+
+func (r *Relay) send(ctx context.Context, batch []*Record) ([]string, *cmq.CMQError) {
+	first := batch[0]
+	if len(batch) == 1 {
+		var msgId string
+		var err *cmq.CMQError
+		if first.Kind == cmq.ResourceQueue {
+			msgId, err = r.account.GetQueue(first.Name).WithContext(ctx).SendMessageWithHeaders(first.Message.Body, first.Message.Headers, first.Message.DelaySeconds)
+		} else {
+			msgId, err = r.account.GetTopic(first.Name).WithContext(ctx).PublishMessageWithHeaders(first.Message.Body, first.Message.Headers, first.Message.Tags, first.Message.RoutingKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []string{msgId}, nil
+	}
+
+	bodies := make([]string, len(batch))
+	for i, rec := range batch {
+		bodies[i] = cmq.WrapBody(rec.Message.Body, rec.Message.Headers)
+	}
+	if first.Kind == cmq.ResourceQueue {
+		return r.account.GetQueue(first.Name).WithContext(ctx).BatchSendMessage(bodies, first.Message.DelaySeconds)
+	}
+	return r.account.GetTopic(first.Name).WithContext(ctx).BatchPublishMessage(bodies, first.Message.Tags, first.Message.RoutingKey)
+}
+
+// 逐条发送批量发送失败的记录，返回发送成功的消息数；
+// 遇到临时错误时停止，ok 为 false，后面的记录等这条记录重试成功后再发送
+func (r *Relay) sendEach(ctx context.Context, batch []*Record) (sent int, ok bool, err error) {
+	for _, rec := range batch {
+		one := []*Record{rec}
+		msgIds, cerr := r.send(ctx, one)
+		if cerr != nil {
+			if err := r.failed(ctx, one, cerr); err != nil {
+				return sent, false, err
+			}
+			if cmq.IsTemporary(cerr) {
+				return sent, false, nil
+			}
+			continue
+		}
+		if err := r.outbox.markSent(ctx, one, msgIds); err != nil {
+			return sent, false, err
+		}
+		sent++
+	}
+	return sent, true, nil
+}
+
+// 记录发送失败，计算下次重试的时间
+// 服务端返回的错误（比如队列已删除）重试也不会成功，直接标记为 StatusFailed
+func (r *Relay) failed(ctx context.Context, batch []*Record, cerr *cmq.CMQError) error {
+	attempts := batch[0].Attempts + 1
+	log.Println("relay outbox message to " + batch[0].Name + " error, attempts: " + strconv.Itoa(attempts) + ", msg: " + cerr.Error())
+	status := StatusPending
+	if !cmq.IsTemporary(cerr) || (r.maxAttempts > 0 && attempts >= r.maxAttempts) {
+		status = StatusFailed
+	}
+	return r.outbox.markFailed(ctx, batch, status, r.now().Add(r.backoff(attempts)), cerr.Error())
+}
+
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.minBackoff
+	for i := 1; i < attempts && d < r.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.maxBackoff {
+		d = r.maxBackoff
+	}
+	return d
+}
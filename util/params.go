@@ -0,0 +1,142 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// 把请求结构体转换为请求参数，字段通过 cmq tag 描述参数名：
+//
+//	QueueName    string        `cmq:"queueName"`
+//	DelaySeconds int           `cmq:"delaySeconds,omitempty"`
+//	MsgBody      []string      `cmq:"msgBody,start=0"`
+//	Timeout      time.Duration `cmq:"timeout,omitempty"`
+//
+// omitempty 表示零值时不传；切片展开为 name.N，N 默认从 1 开始，可以用 start 指定；
+// time.Duration 按秒传递；没有 tag 或 tag 为 "-" 的字段忽略。
+// string、int、int64 保持原类型，其他类型转换为字符串
+func EncodeParams(req interface{}) (map[string]interface{}, error) {
+	v := reflect.ValueOf(req)
+	if !v.IsValid() {
+		return nil, fmt.Errorf("request is nil")
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, fmt.Errorf("request is nil")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("request must be a struct, got %s", v.Type())
+	}
+
+	params := map[string]interface{}{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("cmq")
+		if !ok || tag == "-" {
+			continue
+		}
+		name, omitempty, start, err := parseTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", t.Field(i).Name, err)
+		}
+
+		f := v.Field(i)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				continue
+			}
+			f = f.Elem()
+		}
+		if f.Kind() == reflect.Slice {
+			for j := 0; j < f.Len(); j++ {
+				pv, err := paramValue(f.Index(j))
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %v", t.Field(i).Name, err)
+				}
+				params[name+"."+strconv.Itoa(start+j)] = pv
+			}
+			continue
+		}
+		if omitempty && f.IsZero() {
+			continue
+		}
+		pv, err := paramValue(f)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", t.Field(i).Name, err)
+		}
+		params[name] = pv
+	}
+	return params, nil
+}
+
+func parseTag(tag string) (name string, omitempty bool, start int, err error) {
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	start = 1
+	for _, opt := range parts[1:] {
+		switch {
+		case opt == "omitempty":
+			omitempty = true
+		case strings.HasPrefix(opt, "start="):
+			if start, err = strconv.Atoi(opt[len("start="):]); err != nil {
+				return "", false, 0, fmt.Errorf("invalid tag option %q", opt)
+			}
+		default:
+			return "", false, 0, fmt.Errorf("unknown tag option %q", opt)
+		}
+	}
+	if len(name) == 0 {
+		return "", false, 0, fmt.Errorf("empty parameter name")
+	}
+	return name, omitempty, start, nil
+}
+
+func paramValue(v reflect.Value) (interface{}, error) {
+	if v.Type() == durationType {
+		return int64(v.Interface().(time.Duration) / time.Second), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int:
+		return int(v.Int()), nil
+	case reflect.Int64:
+		return v.Int(), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Bool, reflect.Float32, reflect.Float64:
+		return FormatParam(v.Interface()), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// 参数值的字符串形式，签名和发送请求使用同一种格式
+// 布尔值为 true/false，浮点数使用最短的十进制表示，time.Duration 为秒数
+func FormatParam(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case int:
+		return strconv.Itoa(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case time.Duration:
+		return strconv.FormatInt(int64(x/time.Second), 10)
+	case bool:
+		return strconv.FormatBool(x)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case fmt.Stringer:
+		return x.String()
+	}
+	return fmt.Sprint(v)
+}
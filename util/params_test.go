@@ -0,0 +1,89 @@
+package util
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+type typesRequest struct {
+	Enabled  bool          `cmq:"enabled"`
+	Ratio    float64       `cmq:"ratio"`
+	Timeout  time.Duration `cmq:"timeout"`
+	Count    uint32        `cmq:"count"`
+	Offset   int64         `cmq:"offset"`
+	Limit    *int          `cmq:"limit"`
+	Skipped  string        `cmq:"-"`
+	internal string
+}
+
+func TestEncodeParams(t *testing.T) {
+	limit := 20
+	cases := map[string]interface{}{
+		"types":     &typesRequest{Enabled: true, Ratio: 0.25, Timeout: 90 * time.Second, Count: 7, Offset: 1 << 40, Limit: &limit, Skipped: "x"},
+		"types_nil": &typesRequest{},
+	}
+	for name, req := range cases {
+		params, err := EncodeParams(req)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := MapToURLParam(params, false) + "\n" + MapToURLParam(params, true) + "\n"
+
+		golden := filepath.Join("testdata", name+".golden")
+		if *update {
+			if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", name, got, want)
+		}
+	}
+}
+
+func TestEncodeParams_Invalid(t *testing.T) {
+	cases := []interface{}{
+		nil,
+		(*typesRequest)(nil),
+		"not a struct",
+		&struct {
+			M map[string]string `cmq:"m"`
+		}{M: map[string]string{}},
+		&struct {
+			A string `cmq:"a,unknown"`
+		}{},
+	}
+	for _, req := range cases {
+		if _, err := EncodeParams(req); err == nil {
+			t.Errorf("want error for %#v", req)
+		}
+	}
+}
+
+func TestFormatParam(t *testing.T) {
+	cases := map[interface{}]string{
+		"s":             "s",
+		42:              "42",
+		int64(-1):       "-1",
+		true:            "true",
+		1.5:             "1.5",
+		float32(0.1):    "0.1",
+		3 * time.Second: "3",
+		uint8(255):      "255",
+	}
+	for v, want := range cases {
+		if got := FormatParam(v); got != want {
+			t.Errorf("FormatParam(%#v) = %q, want %q", v, got, want)
+		}
+	}
+}
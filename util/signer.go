@@ -0,0 +1,149 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureMethodHmacSHA256 = "HmacSHA256"
+	SignatureMethodHmacSHA1   = "HmacSHA1"
+	//缺省允许的请求时间和本地时间的最大偏差
+	DefaultMaxSkew = 5 * time.Minute
+)
+
+var (
+	ErrMissingParam      = errors.New("missing signature param")
+	ErrUnknownSecretId   = errors.New("unknown secretId")
+	ErrSignatureMismatch = errors.New("signature mismatch")
+	ErrTimestampSkew     = errors.New("timestamp out of range")
+	ErrNonceReplayed     = errors.New("nonce replayed")
+)
+
+func HmacSHA1(plaintext, secret string) []byte {
+	h := hmac.New(sha1.New, []byte(secret))
+	h.Write([]byte(plaintext))
+	return h.Sum(nil)
+}
+
+// 待签名的字符串：请求方法 + host + path + "?" + 按参数名排序的参数（不含 Signature，不做 URL 编码）
+func CanonicalString(method, host, path string, params map[string]interface{}) string {
+	p := params
+	if _, ok := params["Signature"]; ok {
+		p = make(map[string]interface{}, len(params))
+		for k, v := range params {
+			if k != "Signature" {
+				p[k] = v
+			}
+		}
+	}
+	return method + host + path + "?" + MapToURLParam(p, false)
+}
+
+// 计算签名，signatureMethod 为 HmacSHA256 或 HmacSHA1
+func Sign(method, host, path string, params map[string]interface{}, secretKey, signatureMethod string) string {
+	src := CanonicalString(method, host, path, params)
+	if signatureMethod == SignatureMethodHmacSHA1 {
+		return Base64(HmacSHA1(src, secretKey))
+	}
+	return Base64(HmacSHA256(src, secretKey))
+}
+
+// 根据 secretId 查找 secretKey
+type SecretLookup func(secretId string) (secretKey string, ok bool)
+
+// 校验收到的签名请求：签名、Timestamp 偏差和 Nonce 重放
+type Verifier struct {
+	lookup  SecretLookup
+	maxSkew time.Duration
+	now     func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	pruned time.Time
+}
+
+func NewVerifier(lookup SecretLookup) *Verifier {
+	return &Verifier{
+		lookup:  lookup,
+		maxSkew: DefaultMaxSkew,
+		now:     time.Now,
+		nonces:  map[string]time.Time{},
+	}
+}
+
+// 设置 Timestamp 和本地时间允许的最大偏差，Nonce 在这段时间内不能重复
+func (v *Verifier) SetMaxSkew(maxSkew time.Duration) {
+	v.maxSkew = maxSkew
+}
+
+// 设置当前时间的来源，默认为 time.Now
+func (v *Verifier) SetClock(now func() time.Time) {
+	v.now = now
+}
+
+// 校验 http 请求，参数可以在 query 或 form 中
+func (v *Verifier) VerifyRequest(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	return v.Verify(r.Method, r.Host, r.URL.Path, r.Form)
+}
+
+// 校验请求参数，成功返回 nil
+func (v *Verifier) Verify(method, host, path string, form url.Values) error {
+	params := make(map[string]interface{}, len(form))
+	for k, vs := range form {
+		if len(vs) > 0 {
+			params[k] = vs[0]
+		}
+	}
+	secretId, _ := params["SecretId"].(string)
+	signature, _ := params["Signature"].(string)
+	nonce, _ := params["Nonce"].(string)
+	ts, err := strconv.ParseInt(form.Get("Timestamp"), 10, 64)
+	if len(secretId) == 0 || len(signature) == 0 || len(nonce) == 0 || err != nil {
+		return ErrMissingParam
+	}
+
+	secretKey, ok := v.lookup(secretId)
+	if !ok {
+		return ErrUnknownSecretId
+	}
+	expected := Sign(method, host, path, params, secretKey, form.Get("SignatureMethod"))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrSignatureMismatch
+	}
+
+	now := v.now()
+	t := time.Unix(ts, 0)
+	if t.Before(now.Add(-v.maxSkew)) || t.After(now.Add(v.maxSkew)) {
+		return ErrTimestampSkew
+	}
+	return v.checkNonce(secretId+" "+nonce, t.Add(v.maxSkew), now)
+}
+
+// 记录 nonce，过期时间之前重复出现时返回 ErrNonceReplayed
+func (v *Verifier) checkNonce(key string, expire, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now.Sub(v.pruned) > v.maxSkew {
+		for k, e := range v.nonces {
+			if e.Before(now) {
+				delete(v.nonces, k)
+			}
+		}
+		v.pruned = now
+	}
+	if e, ok := v.nonces[key]; ok && !e.Before(now) {
+		return ErrNonceReplayed
+	}
+	v.nonces[key] = expire
+	return nil
+}
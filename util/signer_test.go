@@ -0,0 +1,101 @@
+package util
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCanonicalString(t *testing.T) {
+	params := map[string]interface{}{
+		"Action":    "SendMessage",
+		"msgBody":   "a b",
+		"Nonce":     11886,
+		"Signature": "ignored",
+	}
+	got := CanonicalString("POST", "cmq-queue-bj.api.qcloud.com", "/v2/index.php", params)
+	want := "POSTcmq-queue-bj.api.qcloud.com/v2/index.php?Action=SendMessage&Nonce=11886&msgBody=a b"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	params := map[string]interface{}{
+		"Action":          "DescribeInstances",
+		"Nonce":           11886,
+		"InstanceIds.0":   "ins-09dx96dg",
+		"SecretId":        "AKIDz8krbsJ5yKBZQpn74WFkmLPx3gnPhESA",
+		"Region":          "ap-guangzhou",
+		"SignatureMethod": "HmacSHA256",
+		"Timestamp":       1465185768,
+	}
+	// 腾讯云 API 文档中的示例
+	if got := Sign("GET", "cvm.api.qcloud.com", "/v2/index.php", params, "Gu5t9xGARNpq86cd98joQYCN3Cozk1qA", SignatureMethodHmacSHA256); got != "0EEm/HtGRr/VJXTAD9tYMth1Bzm3lLHz5RCDv1GdM8s=" {
+		t.Errorf("HmacSHA256 signature = %s", got)
+	}
+	if got := Sign("GET", "cvm.api.qcloud.com", "/v2/index.php", params, "Gu5t9xGARNpq86cd98joQYCN3Cozk1qA", SignatureMethodHmacSHA1); got != "RVSD1I6ip2Zo56I2HdqRVrt+1TE=" {
+		t.Errorf("HmacSHA1 signature = %s", got)
+	}
+}
+
+func signedForm(secretId, secretKey string, ts int64, nonce int) url.Values {
+	params := map[string]interface{}{
+		"Action":          "SendMessage",
+		"queueName":       "queue-a",
+		"SecretId":        secretId,
+		"Nonce":           nonce,
+		"Timestamp":       ts,
+		"SignatureMethod": SignatureMethodHmacSHA256,
+	}
+	params["Signature"] = Sign("POST", "localhost", "/v2/index.php", params, secretKey, SignatureMethodHmacSHA256)
+	form := url.Values{}
+	for k, v := range params {
+		form.Set(k, FormatParam(v))
+	}
+	return form
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v := NewVerifier(func(secretId string) (string, bool) {
+		return "key", secretId == "id"
+	})
+	v.now = func() time.Time { return now }
+
+	if err := v.Verify("POST", "localhost", "/v2/index.php", signedForm("id", "key", now.Unix(), 1)); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		form url.Values
+		want error
+	}{
+		{"replay", signedForm("id", "key", now.Unix(), 1), ErrNonceReplayed},
+		{"wrong key", signedForm("id", "other", now.Unix(), 2), ErrSignatureMismatch},
+		{"unknown id", signedForm("other", "key", now.Unix(), 3), ErrUnknownSecretId},
+		{"expired", signedForm("id", "key", now.Add(-10*time.Minute).Unix(), 4), ErrTimestampSkew},
+		{"future", signedForm("id", "key", now.Add(10*time.Minute).Unix(), 5), ErrTimestampSkew},
+		{"missing", url.Values{"SecretId": {"id"}}, ErrMissingParam},
+	}
+	for _, c := range cases {
+		if err := v.Verify("POST", "localhost", "/v2/index.php", c.form); err != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	tampered := signedForm("id", "key", now.Unix(), 6)
+	tampered.Set("queueName", "queue-b")
+	if err := v.Verify("POST", "localhost", "/v2/index.php", tampered); err != ErrSignatureMismatch {
+		t.Errorf("tampered: got %v", err)
+	}
+
+	// nonce 过期后可以再次使用
+	now = now.Add(2 * DefaultMaxSkew)
+	if err := v.Verify("POST", "localhost", "/v2/index.php", signedForm("id", "key", now.Unix(), 1)); err != nil {
+		t.Errorf("nonce should expire, got %v", err)
+	}
+	if n := len(v.nonces); n != 1 {
+		t.Errorf("expired nonces should be pruned, %d left", n)
+	}
+}
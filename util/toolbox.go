@@ -6,9 +6,7 @@ import (
 	"encoding/base64"
 	"sort"
 	"strings"
-	"strconv"
 	"net/url"
-	"fmt"
 )
 
 func HmacSHA256(plaintext, secret string) []byte {
@@ -22,6 +20,7 @@ func Base64(src []byte) string  {
 	return base64.StdEncoding.EncodeToString(src)
 }
 
+//按参数名排序拼接请求参数，签名时 encoder 为 false，发送请求时为 true
 func MapToURLParam(src map[string]interface{},encoder bool) string {
 	var keys []string
 	for k,_ := range src {
@@ -34,18 +33,11 @@ func MapToURLParam(src map[string]interface{},encoder bool) string {
 
 	for i,k := range keys {
 		key := strings.Replace(k,"_",".",-1)
-		if s,ok := src[k].(string);ok {
-			if encoder {
-				param[i] = key + "=" + url.QueryEscape(s)
-			} else {
-				param[i] = key + "=" + s
-			}
-		} else if s,ok := src[k].(int);ok {
-			param[i] = key + "=" + strconv.Itoa(s)
-		} else if s,ok := src[k].(int64);ok {
-			param[i] = fmt.Sprintf("%s=%d",key,s)
+		s := FormatParam(src[k])
+		if encoder {
+			param[i] = key + "=" + url.QueryEscape(s)
 		} else {
-			param[i] = fmt.Sprintf("%s=%T",key,k)
+			param[i] = key + "=" + s
 		}
 	}
 